@@ -0,0 +1,139 @@
+package main
+
+import "sync"
+
+// Amount of independently locked cache shards, so workers on different chunks don't contend
+const chunkCacheShards = 32
+
+// ChunkCache holds chunks needed again by later chunk parts, split into shards by GUID
+type ChunkCache struct {
+	shards []chunkCacheShard
+}
+
+type chunkCacheShard struct {
+	lock     sync.Mutex
+	data     map[string][]byte
+	parents  map[string]int // chunk parts still needing the chunk
+	inflight map[string]chan struct{}
+}
+
+var chunkCache = newChunkCache()
+
+func newChunkCache() *ChunkCache {
+	return newShardedChunkCache(chunkCacheShards)
+}
+
+// Create a cache of n shards, a single one puts every chunk behind the same lock
+func newShardedChunkCache(n int) *ChunkCache {
+	c := &ChunkCache{shards: make([]chunkCacheShard, n)}
+	for i := range c.shards {
+		c.shards[i].data = make(map[string][]byte)
+		c.shards[i].parents = make(map[string]int)
+		c.shards[i].inflight = make(map[string]chan struct{})
+	}
+
+	return c
+}
+
+// Pick the shard of a chunk with FNV-1a
+func (c *ChunkCache) shard(guid string) *chunkCacheShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(guid); i++ {
+		hash ^= uint32(guid[i])
+		hash *= 16777619
+	}
+
+	return &c.shards[hash%uint32(len(c.shards))]
+}
+
+// AddParents changes the amount of chunk parts needing a chunk
+func (c *ChunkCache) AddParents(guid string, n int) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	s.parents[guid] += n
+	s.lock.Unlock()
+}
+
+// Parents returns the amount of chunk parts still needing a chunk
+func (c *ChunkCache) Parents(guid string) int {
+	s := c.shard(guid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.parents[guid]
+}
+
+// ResetParents forgets all parent counts
+func (c *ChunkCache) ResetParents() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.lock.Lock()
+		s.parents = make(map[string]int)
+		s.lock.Unlock()
+	}
+}
+
+// Used records a chunk part as done with a chunk, evicting the chunk once nothing needs it anymore
+func (c *ChunkCache) Used(guid string) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.parents[guid]--
+	if s.parents[guid] < 1 {
+		delete(s.data, guid)
+	}
+}
+
+// Store caches a chunk if more chunk parts need it
+func (c *ChunkCache) Store(guid string, data []byte) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	if s.parents[guid] > 1 {
+		s.data[guid] = data
+	}
+	s.lock.Unlock()
+}
+
+// Delete drops a cached chunk
+func (c *ChunkCache) Delete(guid string) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	delete(s.data, guid)
+	s.lock.Unlock()
+}
+
+// Lookup returns a cached chunk, waiting for a download of it already in progress.
+// If it's neither cached nor being downloaded, the caller becomes its downloader and must call Fetched when done.
+func (c *ChunkCache) Lookup(guid string) ([]byte, bool) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		if data, ok := s.data[guid]; ok {
+			return data, true
+		}
+
+		wait, busy := s.inflight[guid]
+		if !busy {
+			s.inflight[guid] = make(chan struct{})
+			return nil, false
+		}
+
+		s.lock.Unlock()
+		<-wait
+		s.lock.Lock()
+	}
+}
+
+// Fetched wakes workers waiting for a chunk, it's either cached now or they fetch it themselves
+func (c *ChunkCache) Fetched(guid string) {
+	s := c.shard(guid)
+	s.lock.Lock()
+	if wait, ok := s.inflight[guid]; ok {
+		close(wait)
+		delete(s.inflight, guid)
+	}
+	s.lock.Unlock()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+// Amount of workers hitting the cache at once in the benchmark, about a default download
+const benchmarkCacheWorkers = 50
+
+func BenchmarkChunkCache(b *testing.B) {
+	for _, shards := range []int{chunkCacheShards, 1} {
+		name := "sharded"
+		if shards == 1 {
+			name = "single lock"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			c := newShardedChunkCache(shards)
+			guids := make([]string, 256)
+			for i := range guids {
+				guids[i] = testGUID(i)
+			}
+			data := testData(1, 1024)
+			for _, guid := range guids {
+				c.AddParents(guid, 1<<20)
+			}
+
+			var next uint64
+			procs := runtime.GOMAXPROCS(0)
+			b.SetParallelism((benchmarkCacheWorkers + procs - 1) / procs)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddUint64(&next, 1)
+					guid := guids[n%uint64(len(guids))]
+
+					// Evict now and then so downloads and waits on them are part of the mix
+					if n%16 == 0 {
+						c.Delete(guid)
+					}
+					if _, ok := c.Lookup(guid); !ok {
+						c.Store(guid, data)
+						c.Fetched(guid)
+					}
+					c.Used(guid)
+				}
+			})
+		})
+	}
+}
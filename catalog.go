@@ -2,32 +2,78 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 )
 
+// CatalogElement defines an element within a Catalog
+type CatalogElement struct {
+	AppName      string `json:"appName"`
+	LabelName    string `json:"labelName"`
+	BuildVersion string `json:"buildVersion"`
+	Hash         string `json:"hash"`
+	UseSignedUrl bool   `json:"useSignedUrl"`
+	Manifests    []struct {
+		URI         string `json:"uri"`
+		QueryParams []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"queryParams,omitempty"`
+	} `json:"manifests"`
+}
+
 // Catalog defines a catalog
 type Catalog struct {
-	Elements []struct {
-		AppName      string `json:"appName"`
-		LabelName    string `json:"labelName"`
-		BuildVersion string `json:"buildVersion"`
-		Hash         string `json:"hash"`
-		UseSignedUrl bool   `json:"useSignedUrl"`
-		Manifests    []struct {
-			URI         string `json:"uri"`
-			QueryParams []struct {
-				Name  string `json:"name"`
-				Value string `json:"value"`
-			} `json:"queryParams,omitempty"`
-		} `json:"manifests"`
-	} `json:"elements"`
+	Elements []CatalogElement `json:"elements"`
+}
+
+// FindElement returns the index of the element matching an index or app name
+func (c *Catalog) FindElement(selector string) (int, error) {
+	if i, err := strconv.Atoi(selector); err == nil {
+		if i < 0 || i >= len(c.Elements) {
+			return 0, fmt.Errorf("element index %d out of range", i)
+		}
+
+		return i, nil
+	}
+
+	for i, e := range c.Elements {
+		if strings.EqualFold(e.AppName, selector) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no element with app name %s", selector)
+}
+
+// GetManifestURL returns a manifest url of an element, empty if there is no such element
+func (c *Catalog) GetManifestURL(element int) string {
+	if element < 0 || element >= len(c.Elements) {
+		return ""
+	}
+
+	return c.Elements[element].GetManifestURL()
 }
 
 // GetManifestURL returns a manifest url
-func (c *Catalog) GetManifestURL() string {
-	for _, m := range c.Elements[0].Manifests {
+func (e *CatalogElement) GetManifestURL() string {
+	if urls := e.GetManifestURLs(); len(urls) > 0 {
+		return urls[0]
+	}
+
+	return ""
+}
+
+// GetManifestURLs returns all usable manifest urls
+func (e *CatalogElement) GetManifestURLs() []string {
+	urls := make([]string, 0, len(e.Manifests))
+	for _, m := range e.Manifests {
 		if len(m.QueryParams) == 0 {
-			return m.URI
+			urls = append(urls, m.URI)
+			continue
 		}
 
 		// Ignore options with multiple query params
@@ -50,12 +96,12 @@ func (c *Catalog) GetManifestURL() string {
 			u.RawQuery, err = url.QueryUnescape(query.Encode())
 
 			if err == nil {
-				return u.String()
+				urls = append(urls, u.String())
 			}
 		}
 	}
 
-	return ""
+	return urls
 }
 
 // Parse a catalog from bytes
@@ -63,5 +109,35 @@ func parseCatalog(data []byte) (catalog *Catalog, err error) {
 	catalog = new(Catalog)
 
 	err = json.Unmarshal(data, catalog)
+	if err != nil {
+		return
+	}
+
+	err = catalog.Validate()
 	return
 }
+
+// Validate checks that the catalog has elements and every element has a usable manifest url
+func (c *Catalog) Validate() error {
+	if len(c.Elements) == 0 {
+		return errors.New("catalog has no elements")
+	}
+
+	for i, e := range c.Elements {
+		if len(e.Manifests) == 0 {
+			return fmt.Errorf("element %d (%s) has no manifests", i, e.AppName)
+		}
+
+		for j, m := range e.Manifests {
+			if m.URI == "" {
+				return fmt.Errorf("manifest %d of element %d (%s) has no uri", j, i, e.AppName)
+			}
+		}
+
+		if len(e.GetManifestURLs()) == 0 {
+			return fmt.Errorf("element %d (%s) has no usable manifest url", i, e.AppName)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCatalog(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		wantErr string
+	}{
+		{"valid", `{"elements": [{"appName": "Fortnite", "manifests": [{"uri": "https://cdn/Builds/Fortnite/CloudDir/a.manifest"}]}]}`, ""},
+		{"signed url", `{"elements": [{"appName": "Fortnite", "manifests": [{"uri": "https://cdn/a.manifest", "queryParams": [{"name": "sig", "value": "1"}]}]}]}`, ""},
+		{"no elements", `{"elements": []}`, "catalog has no elements"},
+		{"missing elements", `{}`, "catalog has no elements"},
+		{"empty element", `{"elements": [{}]}`, "element 0 () has no manifests"},
+		{"empty manifests", `{"elements": [{"appName": "Fortnite", "manifests": []}]}`, "element 0 (Fortnite) has no manifests"},
+		{"later element empty", `{"elements": [{"appName": "Fortnite", "manifests": [{"uri": "https://cdn/a.manifest"}]}, {"appName": "Other", "manifests": []}]}`, "element 1 (Other) has no manifests"},
+		{"manifest without uri", `{"elements": [{"appName": "Fortnite", "manifests": [{}]}]}`, "manifest 0 of element 0 (Fortnite) has no uri"},
+		{"only multiple query params", `{"elements": [{"appName": "Fortnite", "manifests": [{"uri": "https://cdn/a.manifest", "queryParams": [{"name": "a", "value": "1"}, {"name": "b", "value": "2"}]}]}]}`, "element 0 (Fortnite) has no usable manifest url"},
+		{"not json", `<html>`, "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, err := parseCatalog([]byte(tt.catalog))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if catalog.GetManifestURL(0) == "" {
+					t.Fatal("no manifest url")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCatalogGetManifestURL(t *testing.T) {
+	catalog, err := parseCatalog([]byte(`{"elements": [{"appName": "Fortnite", "manifests": [
+		{"uri": "https://cdn/a.manifest", "queryParams": [{"name": "a", "value": "1"}, {"name": "b", "value": "2"}]},
+		{"uri": "https://cdn/b.manifest", "queryParams": [{"name": "sig", "value": "x/y"}]}
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url := catalog.GetManifestURL(0); url != "https://cdn/b.manifest?sig=x/y" {
+		t.Fatalf("got %s", url)
+	}
+	if url := catalog.GetManifestURL(1); url != "" {
+		t.Fatalf("got %s for an element out of range", url)
+	}
+	if i, err := catalog.FindElement("fortnite"); err != nil || i != 0 {
+		t.Fatalf("got element %d, %v", i, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Read a checksum file of "filename sha256" pairs, one per line.
+// The sha256sum "sha256 filename" order is accepted as well.
+func readChecksumFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid entry on line %d", line)
+		}
+
+		// Figure out which field is the hash
+		name, hash := fields[0], fields[1]
+		if isSha256(name) && !isSha256(hash) {
+			name, hash = hash, name
+		}
+		if !isSha256(hash) {
+			return nil, fmt.Errorf("invalid sha256 on line %d", line)
+		}
+
+		checksums[filepath.ToSlash(strings.TrimPrefix(name, "*"))] = strings.ToLower(hash)
+	}
+
+	return checksums, scanner.Err()
+}
+
+func isSha256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Verify files against their expected SHA-256 keyed by output path, returns the amount of failed files
+func verifyChecksums(files map[string]ManifestFile, checksums map[string]string) int {
+	infof("Verifying %d files against checksum file...\n", len(checksums))
+
+	pending := make([]ManifestFile, 0, len(checksums))
+	for path := range checksums {
+		pending = append(pending, files[path])
+	}
+
+	matched := 0
+	var matchedLock sync.Mutex
+
+	parallelFiles(pending, func(file ManifestFile) {
+		// Open file
+		f, err := openFile(file.FileName, os.O_RDONLY, 0)
+		if err != nil {
+			errorf("Failed to open %s: %v\n", file.FileName, err)
+			return
+		}
+
+		// Hash file
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, f)
+		f.Close()
+
+		if err != nil {
+			errorf("Failed to hash %s: %v\n", file.FileName, err)
+			return
+		}
+
+		if hex.EncodeToString(hasher.Sum(nil)) != checksums[file.FileName] {
+			errorf("File %s does not match the checksum file\n", file.FileName)
+			return
+		}
+
+		matchedLock.Lock()
+		matched++
+		matchedLock.Unlock()
+	})
+
+	infof("%d of %d files matched the checksum file.\n", matched, len(checksums))
+
+	return len(checksums) - matched
+}
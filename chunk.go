@@ -1,13 +1,21 @@
 package main
 
 import (
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Chunk defines a downloadable chunk
@@ -17,6 +25,7 @@ type Chunk struct {
 	Sha       string
 	DataGroup int
 	FileSize  int64
+	Subdir    string // cloud folder, e.g. ChunksV3
 }
 
 // ChunkPart defines a part of a specific chunk
@@ -27,17 +36,28 @@ type ChunkPart struct {
 
 // ChunkJob defines a job
 type ChunkJob struct {
-	ID    int
-	Chunk Chunk
-	Part  ChunkPart
+	ID         int
+	Chunk      Chunk
+	Part       ChunkPart
+	FileOffset int64  // where the part goes in the output file
+	FailedURL  string // mirror the last attempt failed on
+	Attempts   int    // failed download attempts
 }
 
 // ChunkJobResult defines a result
 type ChunkJobResult struct {
-	Job    ChunkJob
-	Reader ReadSeekCloser
+	Job          ChunkJob
+	Reader       ReadSeekCloser
+	NetworkBytes int64 // bytes downloaded for this job, 0 if read from cache or disk
+	Err          error // set instead of Reader when the chunk couldn't be fetched
 }
 
+// Size of the binary chunk header
+const chunkHeaderSize = 0x3E
+
+// Magic of the binary chunk header
+const chunkMagic = 0xB1FE3AA2
+
 // ChunkHeader defines the binary chunk header
 type ChunkHeader struct {
 	Magic              uint32 // 0xB1FE3AA2
@@ -48,33 +68,324 @@ type ChunkHeader struct {
 	RollingHash        uint64
 	StoredAs           uint8 // 00 = plaintext, 01 = compressed, 02 = encrypted
 	SHAHash            [20]byte
-	HashType           uint8 // strangely 03
+	HashType           uint8 // which hashes are set, see chunkHash*
+}
+
+// Chunk hash types, flags of the hashes set in the header from version 2 on.
+// All chunks seen so far use both.
+const (
+	chunkHashNone    = 0x00
+	chunkHashRolling = 0x01 // RollingHash is set
+	chunkHashSha1    = 0x02 // SHAHash is set, over the decompressed data
+	chunkHashBoth    = chunkHashRolling | chunkHashSha1
+)
+
+// First chunk header version with SHAHash and HashType
+const chunkHeaderVersionSha = 2
+
+// Chunk storage formats
+const (
+	storedAsPlaintext = 0x00
+	storedAsZlib      = 0x01
+	storedAsZstd      = 0x04 // not used by Epic yet, reserved for zstd builds
+)
+
+// Decompressors by chunk storage format, more can be registered by build tags
+var chunkDecompressors = map[uint8]func(io.Reader) (io.ReadCloser, error){
+	storedAsZlib: zlib.NewReader,
 }
 
-// GetURL builds a url
+// GetURL builds a url from -chunk-url-template
 func (c *Chunk) GetURL(cloudURL string) string {
-	return fmt.Sprintf("%s/Builds/Fortnite/CloudDir/ChunksV3/%02d/%s_%s.chunk", cloudURL, c.DataGroup, c.Hash, c.GUID)
+	subdir := c.Subdir
+	if subdir == "" {
+		subdir = "ChunksV3"
+	}
+
+	return strings.NewReplacer(
+		"{url}", cloudURL,
+		"{subdir}", subdir,
+		"{datagroup}", fmt.Sprintf("%02d", c.DataGroup),
+		"{hash}", c.Hash,
+		"{guid}", c.GUID,
+	).Replace(chunkURLTemplate)
 }
 
 // Download fetches the chunk from the internet
-func (c *Chunk) Download(cloudURL string) (data []byte, err error) {
-	// Make GET request
-	resp, err := httpClient.Get(c.GetURL(cloudURL))
+func (c *Chunk) Download(cloudURL string) ([]byte, error) {
+	data, _, err := c.DownloadRange(cloudURL, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch truncated bodies the server didn't announce a length for
+	if c.FileSize > 0 && int64(len(data)) != c.FileSize {
+		return nil, fmt.Errorf("got %d bytes, expected %d", len(data), c.FileSize)
+	}
+
+	return data, nil
+}
+
+// DownloadRange fetches the chunk bytes from start to end inclusive, or to the end of the chunk if end is negative.
+// partial reports whether data is only part of the chunk, if not the server ignored the range or it covered the entire chunk.
+func (c *Chunk) DownloadRange(cloudURL string, start int64, end int64) (data []byte, partial bool, err error) {
+	defer acquireRampSlot()()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := c.request(ctx, cloudURL, start, end)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	partial = resp.StatusCode == http.StatusPartialContent && !wholeRange(resp.Header.Get("Content-Range"))
+
+	// Read data
+	if stallTimeout > 0 {
+		stallReader := NewStallReader(resp.Body, cancel, stallSpeed, stallTimeout)
+		defer stallReader.Stop()
+
+		data, err = ioutil.ReadAll(stallReader)
+	} else {
+		data, err = ioutil.ReadAll(resp.Body)
+	}
 	if err != nil {
 		return
 	}
+
+	// A connection closed early can look like a complete response
+	if resp.ContentLength >= 0 && int64(len(data)) != resp.ContentLength {
+		err = fmt.Errorf("short body, got %d of %d bytes", len(data), resp.ContentLength)
+	}
+
+	return
+}
+
+// Whether a Content-Range header covers an entire file, which some servers answer ranges reaching past the end with
+func wholeRange(contentRange string) bool {
+	var start, end, total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return false
+	}
+
+	return start == 0 && end == total-1
+}
+
+// DownloadTo streams the whole chunk from the internet to w without holding it in memory.
+// Returns the amount of bytes written.
+func (c *Chunk) DownloadTo(cloudURL string, w io.Writer) (int64, error) {
+	defer acquireRampSlot()()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := c.request(ctx, cloudURL, 0, -1)
+	if err != nil {
+		return 0, err
+	}
 	defer resp.Body.Close()
 
+	// Copy data
+	var body io.Reader = resp.Body
+	if stallTimeout > 0 {
+		stallReader := NewStallReader(resp.Body, cancel, stallSpeed, stallTimeout)
+		defer stallReader.Stop()
+
+		body = stallReader
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return n, err
+	}
+
+	// A connection closed early can look like a complete response
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return n, fmt.Errorf("short body, got %d of %d bytes", n, resp.ContentLength)
+	}
+	if c.FileSize > 0 && n != c.FileSize {
+		return n, fmt.Errorf("got %d bytes, expected %d", n, c.FileSize)
+	}
+
+	return n, nil
+}
+
+// Request the chunk bytes from start to end, see DownloadRange. The caller closes the response body.
+func (c *Chunk) request(ctx context.Context, cloudURL string, start int64, end int64) (*http.Response, error) {
+	// Create http request
+	req, err := http.NewRequestWithContext(ctx, "GET", c.GetURL(cloudURL), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks are already compressed
+	req.Header.Set("Accept-Encoding", "identity")
+	setMirrorAuth(req)
+
+	// Set range
+	if end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	} else if start > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+
+	// Spread requests out so mirrors don't see bursts
+	waitRequestJitter()
+
+	// Make GET request
+	sent := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		recordMirrorTime(cloudURL, time.Since(sent), err)
+		return nil, err
+	}
+
 	// Check response code
 	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
 		err = fmt.Errorf("invalid status code %d", resp.StatusCode)
-		return
+		recordMirrorTime(cloudURL, time.Since(sent), err)
+		return nil, err
 	}
 
-	// Read data
-	data, err = ioutil.ReadAll(resp.Body)
+	// Time the whole download for -mirror-strategy latency
+	resp.Body = &timedBody{ReadCloser: resp.Body, downloadURL: cloudURL, start: sent}
 
-	return
+	return resp, nil
+}
+
+// DownloadPart fetches only as much of the chunk as is needed for a part.
+// Only the header and data up to the end of the part are requested, which is enough if the chunk is stored uncompressed.
+// Compressed chunks can't be read partially, so the rest of the chunk is fetched for those.
+// Also returns the amount of bytes downloaded.
+func (c *Chunk) DownloadPart(cloudURL string, part ChunkPart) (ReadSeekCloser, int64, error) {
+	// Compressed chunks are usually smaller than the range, which would then be the whole chunk anyway
+	end := chunkHeaderSize + int64(part.Offset) + int64(part.Size) - 1
+	if c.FileSize > 0 && end >= c.FileSize-1 {
+		end = -1
+	}
+
+	data, partial, err := c.DownloadRange(cloudURL, 0, end)
+	if err != nil {
+		return nil, 0, err
+	}
+	downloaded := int64(len(data))
+
+	// All of it arrived, nothing is left to fetch
+	if c.FileSize > 0 && downloaded >= c.FileSize {
+		partial = false
+	}
+
+	if partial {
+		// Use partial data if stored uncompressed
+		header, err := readChunkHeader(NewByteCloser(data))
+		if err == nil && header.StoredAs == storedAsPlaintext && int64(header.HeaderSize) == chunkHeaderSize {
+			reader := NewByteCloser(data)
+			reader.Seek(chunkHeaderSize, io.SeekStart)
+			return reader, downloaded, nil
+		}
+
+		// Fetch the rest
+		rest, partial, err := c.DownloadRange(cloudURL, int64(len(data)), -1)
+		if err != nil {
+			return nil, downloaded, err
+		}
+		downloaded += int64(len(rest))
+
+		if partial {
+			data = append(data, rest...)
+		} else {
+			data = rest
+		}
+	}
+
+	// Only used once, so decompress as it's read
+	reader, err := parseChunkStream(NewByteCloser(data))
+	return reader, downloaded, err
+}
+
+// Verify checks raw chunk data, header included, against the chunk's expected SHA-1.
+// r is closed once done.
+func (c *Chunk) Verify(r ReadSeekCloser) error {
+	if c.Sha == "" {
+		r.Close()
+		return fmt.Errorf("no sha known for chunk %s", c.GUID)
+	}
+
+	// Parse chunk
+	reader, err := parseStoredChunk(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse: %v", err)
+	}
+	defer reader.Close()
+
+	return c.checkData(reader)
+}
+
+// VerifyFile checks a chunk file in either chunk folder format against the chunk's expected SHA-1
+func (c *Chunk) VerifyFile(path string) error {
+	f, err := openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	return c.Verify(f)
+}
+
+// Check the rolling hash in a raw chunk's header against the manifest, rewinding the reader afterwards.
+// Chunks without a header, such as decompressed ones in the chunk folder, and headers without a rolling hash pass.
+func (c *Chunk) checkRollingHash(r ReadSeekCloser) error {
+	header, err := readChunkHeader(r)
+	if _, seekErr := r.Seek(0, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil || header.Magic != chunkMagic {
+		return nil
+	}
+
+	if header.Version >= chunkHeaderVersionSha && header.HashType&chunkHashRolling == 0 {
+		return nil
+	}
+
+	expected, err := strconv.ParseUint(c.Hash, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid rolling hash %s in manifest", c.Hash)
+	}
+
+	if header.RollingHash != expected {
+		return fmt.Errorf("rolling hash mismatch, expected %016X got %016X", expected, header.RollingHash)
+	}
+
+	return nil
+}
+
+// Check parsed chunk data against the expected SHA-1, rewinding the reader afterwards
+func (c *Chunk) checkData(r io.ReadSeeker) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkSum(r); err != nil {
+		return err
+	}
+
+	_, err = r.Seek(start, io.SeekStart)
+	return err
+}
+
+// Check the rest of a reader against the expected SHA-1 without rewinding it
+func (c *Chunk) checkSum(r io.Reader) error {
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return fmt.Errorf("failed to hash: %v", err)
+	}
+
+	if sum := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(sum, c.Sha) {
+		return fmt.Errorf("sha mismatch, expected %s got %s", strings.ToLower(c.Sha), sum)
+	}
+
+	return nil
 }
 
 // NewChunk create a chunk object
@@ -105,11 +416,18 @@ func NewChunkInt(guid string, hash string, sha string, dataGroup string, fileSiz
 	return Chunk{
 		GUID:      guid,
 		Hash:      hash,
+		Sha:       sha,
 		DataGroup: dg,
 		FileSize:  int64(fileSize),
 	}
 }
 
+// Check if a chunk exists in a chunk folder
+func chunkOnDisk(dir string, guid string) bool {
+	_, err := os.Stat(filepath.Join(dir, guid))
+	return err == nil
+}
+
 func readChunkHeader(r ReadSeekCloser) (ChunkHeader, error) {
 	// Initialize empty header
 	header := ChunkHeader{}
@@ -138,3 +456,25 @@ func readPackedData(packed string) []byte {
 func readPackedUint32(packed string) uint32 {
 	return binary.LittleEndian.Uint32(readPackedData(packed))
 }
+
+// Inverse of readPackedData, every byte is written as three decimal digits
+func writePackedData(data []byte) string {
+	var packed strings.Builder
+	for _, b := range data {
+		fmt.Fprintf(&packed, "%03d", b)
+	}
+
+	return packed.String()
+}
+
+func writePackedUint32(n uint32) string {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, n)
+	return writePackedData(data)
+}
+
+func writePackedUint64(n uint64) string {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, n)
+	return writePackedData(data)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Build a raw chunk around data, padding the header to headerSize
+func makeTestChunk(t *testing.T, data []byte, storedAs uint8, headerSize int, rollingHash uint64) []byte {
+	t.Helper()
+
+	stored := data
+	if storedAs == storedAsZlib {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write(data)
+		w.Close()
+		stored = compressed.Bytes()
+	}
+
+	header := ChunkHeader{
+		Magic:              chunkMagic,
+		Version:            3,
+		HeaderSize:         uint32(headerSize),
+		DataSizeCompressed: uint32(len(stored)),
+		RollingHash:        rollingHash,
+		StoredAs:           storedAs,
+		SHAHash:            sha1.Sum(data),
+		HashType:           chunkHashBoth,
+	}
+
+	var raw bytes.Buffer
+	if err := binary.Write(&raw, binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	raw.Write(make([]byte, headerSize-raw.Len()))
+	raw.Write(stored)
+
+	return raw.Bytes()
+}
+
+func TestCheckRollingHash(t *testing.T) {
+	raw := makeTestChunk(t, []byte("chunk data"), storedAsZlib, chunkHeaderSize, 0x0123456789ABCDEF)
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr string
+	}{
+		{"match", "0123456789ABCDEF", ""},
+		{"lowercase", "0123456789abcdef", ""},
+		{"mismatch", "0123456789ABCDEE", "rolling hash mismatch"},
+		{"byte reversed", "EFCDAB8967452301", "rolling hash mismatch"},
+		{"invalid", "not a hash", "invalid rolling hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := Chunk{GUID: "0123456789ABCDEF0123456789ABCDEF", Hash: tt.hash}
+			r := NewByteCloser(raw)
+
+			err := chunk.checkRollingHash(r)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			// The reader is rewound for parsing
+			if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+				t.Fatalf("reader left at %d", pos)
+			}
+		})
+	}
+}
+
+func TestChunkURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		dirFlag int
+		want    string
+	}{
+		{"ChunksV3 from json", "013000000000", 0, "https://cdn/Builds/Fortnite/CloudDir/ChunksV3/07/0123456789ABCDEF_B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4.chunk"},
+		{"ChunksV4 from binary", "18", 0, "https://cdn/Builds/Fortnite/CloudDir/ChunksV4/07/0123456789ABCDEF_B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4.chunk"},
+		{"unknown version", "", 0, "https://cdn/Builds/Fortnite/CloudDir/ChunksV3/07/0123456789ABCDEF_B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4.chunk"},
+		{"forced by flag", "18", 2, "https://cdn/Builds/Fortnite/CloudDir/ChunksV2/07/0123456789ABCDEF_B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4.chunk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := chunkDirVersion
+			chunkDirVersion = tt.dirFlag
+			defer func() { chunkDirVersion = previous }()
+
+			manifest := &Manifest{
+				ManifestFileVersion:  tt.version,
+				ChunkHashList:        map[string]string{"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "0123456789ABCDEF"},
+				DataGroupList:        map[string]string{"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "7"},
+				ChunkFilesizeListInt: map[string]uint64{"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": 1},
+			}
+			chunk := manifest.GetChunk("B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4")
+			if url := chunk.GetURL("https://cdn"); url != tt.want {
+				t.Fatalf("got %s, want %s", url, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkURLTemplate(t *testing.T) {
+	setGlobal(t, &chunkURLTemplate, "{url}/{guid}.chunk")
+
+	chunk := Chunk{GUID: "B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4", Hash: "0123456789ABCDEF", DataGroup: 7}
+	if url := chunk.GetURL("https://mirror/flat"); url != "https://mirror/flat/B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4.chunk" {
+		t.Fatalf("got %s", url)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build zstd
+// +build zstd
+
+package main
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Build with -tags zstd to read zstd compressed chunks
+func init() {
+	chunkDecompressors[storedAsZstd] = func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	}
+}
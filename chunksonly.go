@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Download chunks to the chunk folder without assembling files, returns the exit code
+func downloadChunks(chunks map[string]Chunk) int {
+	infof("Downloading %d chunks...\n", len(chunks))
+
+	// Load resume state
+	var state *ChunkState
+	if resumeChunks {
+		var err error
+		state, err = openChunkState(chunkPath)
+		if err != nil {
+			errorf("Failed to open chunk state: %v\n", err)
+			return exitFatal
+		}
+		defer state.Close()
+	}
+
+	// Build job queue
+	var remainingBytes int64
+	jobs := make(chan Chunk, len(chunks))
+	for _, chunk := range chunks {
+		remainingBytes += chunk.FileSize
+		jobs <- chunk
+	}
+	close(jobs)
+	diskFull := false
+	var failedChunks int64
+
+	// Workers
+	var wg sync.WaitGroup
+	for i := 0; i < chunkWorkerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if stopRequested() {
+					return
+				}
+
+				filePath := filepath.Join(chunkPath, j.GUID)
+
+				// Check if present on disk and intact, decompressed chunks have a different size
+				if fi, err := os.Stat(filePath); err == nil && (fi.Size() == j.FileSize || chunkStoreFormat == chunkStoreDecompressed) {
+					if state != nil && state.IsVerified(j.GUID, fi.Size()) {
+						atomic.AddInt64(&remainingBytes, -j.FileSize)
+						continue
+					}
+
+					if j.Sha == "" || j.VerifyFile(filePath) == nil {
+						if state != nil {
+							state.MarkVerified(j.GUID, fi.Size())
+						}
+
+						atomic.AddInt64(&remainingBytes, -j.FileSize)
+						continue
+					}
+				}
+
+				// Stream chunk to disk, the job queue is closed so retry in place
+				var size int64
+				done := false
+				failedURL := ""
+				for attempt := 0; attempt < chunkOnlyAttempts && !stopRequested(); attempt++ {
+					downloadURL := pickDownloadURL(failedURL)
+					networkBytes, stored, err := downloadChunkFile(j, downloadURL, filePath)
+					if err == nil {
+						recordMirrorRequest(downloadURL, networkBytes, nil)
+						size, done = stored, true
+						break
+					}
+
+					// Wait for space and try again without counting it against the mirror
+					if isDiskFull(err) {
+						recordMirrorRequest(downloadURL, networkBytes, nil)
+						if !handleDiskFull(filePath, atomic.LoadInt64(&remainingBytes)) {
+							requestStop()
+							diskFull = true
+							break
+						}
+						attempt--
+						continue
+					}
+
+					recordMirrorRequest(downloadURL, networkBytes, err)
+					coalescef(levelWarn, chunkFailureKey(downloadURL, err), "Failed to download chunk %s: %v\n", j.GUID, err)
+					failedURL = downloadURL
+				}
+				if !done {
+					if !stopRequested() {
+						atomic.AddInt64(&failedChunks, 1)
+					}
+					continue
+				}
+
+				if state != nil && j.Sha != "" {
+					state.MarkVerified(j.GUID, size)
+				}
+				atomic.AddInt64(&remainingBytes, -j.FileSize)
+			}
+		}()
+	}
+
+	// Wait for all goroutines
+	wg.Wait()
+	flushCoalescedLogs()
+
+	if diskFull {
+		errorf("Stopped, run splash again once space has been freed to resume.")
+		return exitFatal
+	}
+
+	if stopRequested() {
+		return exitFatal
+	}
+
+	if failedChunks > 0 {
+		errorf("Done, %d chunks failed to download.\n", failedChunks)
+		return exitDownloadFailed
+	}
+
+	infof("Done!")
+	return exitOK
+}
+
+// Stream a chunk into the chunk folder through a partial file, it's only moved into place once verified.
+// Returns the amount of bytes downloaded and the size of the stored chunk.
+func downloadChunkFile(chunk Chunk, downloadURL string, filePath string) (int64, int64, error) {
+	partialPath := tempPath(filePath)
+	trackPartial(partialPath)
+	defer untrackPartial(partialPath)
+
+	f, err := openFile(partialPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	networkBytes, err := chunk.DownloadTo(downloadURL, f)
+	if err == nil && verifyRollingHash {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			err = chunk.checkRollingHash(f)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	// Verify chunk data
+	if err == nil && chunk.Sha != "" {
+		err = chunk.VerifyFile(partialPath)
+	}
+
+	var size int64
+	if err == nil {
+		size, err = storeChunkFile(partialPath, filePath)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return networkBytes, 0, err
+	}
+
+	return networkBytes, size, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import "encoding/json"
+
+// Name of the resume state file in the chunk folder
+const chunkStateFile = ".splash-chunks.json"
+
+// ChunkStateEntry defines a verified chunk, one json object is written per line
+type ChunkStateEntry struct {
+	GUID string `json:"guid"`
+	Size int64  `json:"size"`
+}
+
+// ChunkState records which chunks in the chunk folder were already verified
+type ChunkState struct {
+	*stateFile
+	verified map[string]int64
+}
+
+// IsVerified checks if a chunk of the given size was verified before
+func (cs *ChunkState) IsVerified(guid string, size int64) bool {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	verifiedSize, ok := cs.verified[guid]
+	return ok && verifiedSize == size
+}
+
+// MarkVerified records a chunk as verified
+func (cs *ChunkState) MarkVerified(guid string, size int64) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if verifiedSize, ok := cs.verified[guid]; ok && verifiedSize == size {
+		return
+	}
+
+	cs.verified[guid] = size
+	if err := cs.append(ChunkStateEntry{guid, size}); err != nil {
+		warnf("Failed to write chunk state: %v\n", err)
+	}
+}
+
+// Open the chunk state of a chunk folder, creating it if needed
+func openChunkState(dir string) (*ChunkState, error) {
+	state := &ChunkState{verified: make(map[string]int64)}
+
+	var err error
+	state.stateFile, err = openStateFile(dir, chunkStateFile, func(line []byte) {
+		var entry ChunkStateEntry
+		if json.Unmarshal(line, &entry) == nil {
+			state.verified[entry.GUID] = entry.Size
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
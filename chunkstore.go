@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Chunk folder formats
+const (
+	chunkStoreRaw          = "raw"          // chunks as downloaded, compressed with their header
+	chunkStoreDecompressed = "decompressed" // only the chunk data, bigger on disk but no parsing on read
+)
+
+// Convert a downloaded chunk to the -chunk-store-format for writing to the chunk folder
+func storedChunkData(raw []byte) ([]byte, error) {
+	if chunkStoreFormat == chunkStoreRaw {
+		return raw, nil
+	}
+
+	reader, _, err := parseChunk(NewByteCloser(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// Move a downloaded raw chunk file into the chunk folder in the -chunk-store-format, converting it as it's read.
+// Returns the size of the stored chunk.
+func storeChunkFile(rawPath string, filePath string) (int64, error) {
+	if chunkStoreFormat == chunkStoreRaw {
+		fi, err := os.Stat(rawPath)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), moveFile(rawPath, filePath)
+	}
+
+	in, err := openFile(rawPath, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	reader, err := parseChunkStream(in)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(rawPath)
+	defer reader.Close()
+
+	partialPath := rawPath + ".decompressed"
+	trackPartial(partialPath)
+	defer untrackPartial(partialPath)
+
+	out, err := openFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = moveFile(partialPath, filePath)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// Parse a chunk read from the chunk folder in either format.
+// Raw chunks start with the chunk header magic, anything else is taken as decompressed chunk data.
+func parseStoredChunk(reader ReadSeekCloser) (ReadSeekCloser, error) {
+	magic := make([]byte, 4)
+	_, err := io.ReadFull(reader, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		reader.Close()
+		return nil, err
+	}
+
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(magic) != chunkMagic {
+		return reader, nil
+	}
+
+	if lowMemory {
+		return parseChunkStream(reader)
+	}
+
+	chunkReader, _, err := parseChunk(reader)
+	return chunkReader, err
+}
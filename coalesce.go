@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Window in which repeated similar messages are counted instead of logged
+const logCoalesceWindow = 5 * time.Second
+
+type coalescedLog struct {
+	level      int
+	suppressed int
+	timer      *time.Timer
+}
+
+var coalescedLogs = make(map[string]*coalescedLog)
+var coalescedLogsLock sync.Mutex
+
+// Log a message unless one with the same key was logged in the last window.
+// Suppressed messages are summarized in one line per window, using key to describe them.
+// Nothing is suppressed at the debug log level.
+func coalescef(level int, key string, format string, v ...interface{}) {
+	if logLevel <= levelDebug {
+		logf(level, format, v...)
+		return
+	}
+
+	coalescedLogsLock.Lock()
+	defer coalescedLogsLock.Unlock()
+
+	if entry, ok := coalescedLogs[key]; ok {
+		entry.suppressed++
+		return
+	}
+
+	logf(level, format, v...)
+
+	entry := &coalescedLog{level: level}
+	entry.timer = time.AfterFunc(logCoalesceWindow, func() { endCoalesceWindow(key, entry) })
+	coalescedLogs[key] = entry
+}
+
+// Summarize the messages suppressed in a window, keeping the key coalesced while they keep coming
+func endCoalesceWindow(key string, entry *coalescedLog) {
+	coalescedLogsLock.Lock()
+	defer coalescedLogsLock.Unlock()
+
+	if coalescedLogs[key] != entry {
+		return
+	}
+
+	if entry.suppressed == 0 {
+		delete(coalescedLogs, key)
+		return
+	}
+
+	logf(entry.level, "%d more %s in the last %s.\n", entry.suppressed, key, logCoalesceWindow)
+	entry.suppressed = 0
+	entry.timer.Reset(logCoalesceWindow)
+}
+
+// Log the summaries of all open windows, called before the end of run output
+func flushCoalescedLogs() {
+	coalescedLogsLock.Lock()
+	defer coalescedLogsLock.Unlock()
+
+	for key, entry := range coalescedLogs {
+		entry.timer.Stop()
+		if entry.suppressed > 0 {
+			logf(entry.level, "%d more %s.\n", entry.suppressed, key)
+		}
+		delete(coalescedLogs, key)
+	}
+}
+
+// Key of chunk download failures on a mirror, without the chunk url so failures of different chunks look alike
+func chunkFailureKey(mirror string, err error) string {
+	reason := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		reason = urlErr.Err.Error()
+	}
+
+	return "chunk downloads from " + mirror + " failed with " + reason
+}
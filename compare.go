@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// InstallComparison defines how a folder differs from the manifests
+type InstallComparison struct {
+	Folder       string   `json:"folder"`
+	Matching     int      `json:"matching"`
+	Missing      []string `json:"missing"`
+	Extra        []string `json:"extra"` // files none of the manifests contain
+	SizeMismatch []string `json:"sizeMismatch"`
+	HashMismatch []string `json:"hashMismatch"`
+}
+
+// Compare the files in dir against the manifests without changing anything.
+// Files selected by the filters are checked, extra files are found regardless of filters like -prune does.
+func compareInstall(manifests []*Manifest, dir string) *InstallComparison {
+	comparison := &InstallComparison{
+		Folder:       dir,
+		Missing:      make([]string, 0),
+		Extra:        make([]string, 0),
+		SizeMismatch: make([]string, 0),
+		HashMismatch: make([]string, 0),
+	}
+
+	// Collect selected files, later manifests win like they do for downloads
+	files := make(map[string]ManifestFile)
+	for _, manifest := range manifests {
+		for _, file := range manifest.FileManifestList {
+			if !matchesFilter(file.FileName) || !matchesInstallTags(file) {
+				continue
+			}
+
+			files[outputPath(outputLayout, dir, manifest.BuildVersionString, file.FileName)] = file
+		}
+	}
+
+	pending := make([]ManifestFile, 0, len(files))
+	for path, file := range files {
+		file.FileName = path
+		pending = append(pending, file)
+	}
+
+	// Check every file, the size first so only files that could match are hashed
+	var lock sync.Mutex
+	parallelFiles(pending, func(file ManifestFile) {
+		list := &comparison.HashMismatch
+		fi, err := os.Stat(file.FileName)
+		switch {
+		case err != nil:
+			list = &comparison.Missing
+		case uint64(fi.Size()) != file.Size():
+			list = &comparison.SizeMismatch
+		default:
+			f, err := storage.Open(file.FileName)
+			if err == nil {
+				var equal bool
+				equal, err = checkFile(f, file)
+				f.Close()
+				if err == nil && equal {
+					list = nil
+				}
+			}
+			if err != nil {
+				warnf("Failed to check %s: %v\n", file.FileName, err)
+			}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if list == nil {
+			comparison.Matching++
+			return
+		}
+		*list = append(*list, relativePath(dir, file.FileName))
+	})
+
+	// Find files none of the manifests contain
+	roots, known := manifestOutputPaths(manifests, dir)
+	for root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			if info.IsDir() {
+				if isSplashFolder(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !known[path] && !isSplashFile(path) {
+				comparison.Extra = append(comparison.Extra, relativePath(dir, path))
+			}
+
+			return nil
+		})
+	}
+
+	sort.Strings(comparison.Missing)
+	sort.Strings(comparison.Extra)
+	sort.Strings(comparison.SizeMismatch)
+	sort.Strings(comparison.HashMismatch)
+
+	return comparison
+}
+
+// Differences counts the files that differ from the manifests
+func (c *InstallComparison) Differences() int {
+	return len(c.Missing) + len(c.Extra) + len(c.SizeMismatch) + len(c.HashMismatch)
+}
+
+// Print a comparison in human readable form, or as json
+func printComparison(comparison *InstallComparison, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(comparison)
+	}
+
+	fmt.Printf("%s\n", comparison.Folder)
+	for _, name := range comparison.Missing {
+		fmt.Printf("  - %s\n", name)
+	}
+	for _, name := range comparison.Extra {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range comparison.SizeMismatch {
+		fmt.Printf("  ~ %s (size)\n", name)
+	}
+	for _, name := range comparison.HashMismatch {
+		fmt.Printf("  ~ %s (hash)\n", name)
+	}
+	fmt.Printf("%d matching, %d missing, %d extra, %d differ in size, %d differ in hash.\n", comparison.Matching, len(comparison.Missing), len(comparison.Extra), len(comparison.SizeMismatch), len(comparison.HashMismatch))
+
+	return nil
+}
+
+// Make a path relative to dir for reports, keeping it as is if that fails
+func relativePath(dir string, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+
+	return filepath.ToSlash(rel)
+}
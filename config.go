@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Apply flags from a json config file, flags given on the command line take precedence.
+// Keys are flag names, lists are joined with commas, e.g. {"workers": 20, "url": ["http://a", "http://b"]}
+func applyConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	// Flags set on the command line
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range config {
+		if name == "config" {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if set[name] {
+			continue
+		}
+
+		if err := flag.Set(name, configValue(value)); err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Format a config value the way it would be given on the command line
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, element := range v {
+			values[i] = configValue(element)
+		}
+		return strings.Join(values, ",")
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		// Bools
+		return fmt.Sprint(v)
+	}
+}
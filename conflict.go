@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Conflict policies
+const (
+	conflictLast  = "last"  // keep the file from the last manifest
+	conflictFirst = "first" // keep the file from the first manifest
+	conflictError = "error" // abort
+)
+
+// Resolve two manifests contributing the same output path, returns true if the new file should replace the existing one
+func resolveConflict(existing ManifestFile, file ManifestFile, existingVersion string, version string) bool {
+	// Identical files aren't a conflict
+	if strings.EqualFold(existing.FileHash, file.FileHash) {
+		return false
+	}
+
+	switch conflictPolicy {
+	case conflictError:
+		log.Fatalf("File %s differs between %s and %s", file.FileName, existingVersion, version)
+	case conflictFirst:
+		warnf("File %s differs between %s and %s, keeping %s.\n", file.FileName, existingVersion, version, existingVersion)
+		return false
+	}
+
+	warnf("File %s differs between %s and %s, keeping %s.\n", file.FileName, existingVersion, version, version)
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// Key identical files share, empty files aren't worth linking
+func dedupKey(file ManifestFile) string {
+	if file.Size() == 0 {
+		return ""
+	}
+
+	hash, err := file.Hash()
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(hash)
+}
+
+// Hardlink dst to an identical file already in place, through its temp path so dst is replaced atomically
+func linkFile(src string, dst string) error {
+	if err := checkSymlinkEscape(dst); err != nil {
+		return err
+	}
+
+	linkPath := tempPath(dst)
+	os.Remove(linkPath)
+	os.MkdirAll(filepath.Dir(linkPath), os.ModePerm)
+	if err := os.Link(src, linkPath); err != nil {
+		return err
+	}
+
+	os.MkdirAll(filepath.Dir(dst), dirMode)
+	if err := os.Rename(linkPath, dst); err != nil {
+		os.Remove(linkPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ManifestDiff defines the changes between two manifests
+type ManifestDiff struct {
+	From         string   `json:"from"`
+	To           string   `json:"to"`
+	Added        []string `json:"added"`
+	Removed      []string `json:"removed"`
+	Modified     []string `json:"modified"`
+	NewChunks    int      `json:"newChunks"`
+	DownloadSize int64    `json:"downloadSize"`
+}
+
+// Compare two manifests
+func diffManifests(a *Manifest, b *Manifest) *ManifestDiff {
+	diff := &ManifestDiff{
+		From:     a.BuildVersionString,
+		To:       b.BuildVersionString,
+		Added:    make([]string, 0),
+		Removed:  make([]string, 0),
+		Modified: make([]string, 0),
+	}
+
+	// Index old files and chunks
+	oldFiles := make(map[string]ManifestFile)
+	oldChunks := make(map[string]bool)
+	for _, file := range a.FileManifestList {
+		oldFiles[file.FileName] = file
+		for _, c := range file.FileChunkParts {
+			oldChunks[c.GUID] = true
+		}
+	}
+
+	// Compare new files
+	newChunks := make(map[string]bool)
+	for _, file := range b.FileManifestList {
+		if old, ok := oldFiles[file.FileName]; !ok {
+			diff.Added = append(diff.Added, file.FileName)
+		} else if !sameFileHash(old, file) {
+			diff.Modified = append(diff.Modified, file.FileName)
+		}
+		delete(oldFiles, file.FileName)
+
+		// Count chunks unique to the new manifest
+		for _, c := range file.FileChunkParts {
+			if oldChunks[c.GUID] || newChunks[c.GUID] {
+				continue
+			}
+
+			newChunks[c.GUID] = true
+			diff.DownloadSize += b.GetChunk(c.GUID).FileSize
+		}
+	}
+	diff.NewChunks = len(newChunks)
+
+	// Remaining files were removed
+	for name := range oldFiles {
+		diff.Removed = append(diff.Removed, name)
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+
+	return diff
+}
+
+// Compare the hashes of two files, which may be in different formats when diffing a json and a binary manifest
+func sameFileHash(a ManifestFile, b ManifestFile) bool {
+	hashA, errA := a.Hash()
+	hashB, errB := b.Hash()
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a.FileHash, b.FileHash)
+	}
+
+	return bytes.Equal(hashA, hashB)
+}
+
+// Print a diff in human readable form, or as json
+func printDiff(diff *ManifestDiff, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+
+	fmt.Printf("%s -> %s\n", diff.From, diff.To)
+	for _, name := range diff.Added {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("  - %s\n", name)
+	}
+	for _, name := range diff.Modified {
+		fmt.Printf("  ~ %s\n", name)
+	}
+	fmt.Printf("%d added, %d removed, %d modified.\n", len(diff.Added), len(diff.Removed), len(diff.Modified))
+	fmt.Printf("%d new chunks, %s to download.\n", diff.NewChunks, formatBytes(diff.DownloadSize))
+
+	return nil
+}
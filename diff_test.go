@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSONAgainstBinary(t *testing.T) {
+	// The same build as a binary manifest and as json, hashes in hex and packed
+	old := testBinaryManifest()
+	old.FileManifestList = append(old.FileManifestList,
+		ManifestFile{
+			FileName:       "FortniteGame/Content/Paks/pakchunk1.pak",
+			FileHash:       "ffeeddccbbaa99887766554433221100ffeeddcc",
+			InstallTags:    []string{},
+			FileChunkParts: []ManifestFileChunkPart{{GUID: "0123456789ABCDEF0123456789ABCDEF", Offset: "0", Size: "10", SizeInt: 10}},
+		},
+		ManifestFile{
+			FileName:       "FortniteGame/Content/Paks/removed.pak",
+			FileHash:       "0000000000000000000000000000000000000000",
+			InstallTags:    []string{},
+			FileChunkParts: []ManifestFileChunkPart{{GUID: "0123456789ABCDEF0123456789ABCDEF", Offset: "0", Size: "10", SizeInt: 10}},
+		},
+	)
+	binaryManifest, err := parseManifest(encodeBinaryManifest(t, old, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new build changes one file and adds another
+	next := testBinaryManifest()
+	next.FileManifestList = append(next.FileManifestList,
+		ManifestFile{
+			FileName:       "FortniteGame/Content/Paks/pakchunk1.pak",
+			FileHash:       "1111111111111111111111111111111111111111",
+			InstallTags:    []string{},
+			FileChunkParts: []ManifestFileChunkPart{{GUID: "FEDCBA9876543210FEDCBA9876543210", Offset: "0", Size: "10", SizeInt: 10}},
+		},
+		ManifestFile{
+			FileName:       "FortniteGame/Content/Paks/added.pak",
+			FileHash:       "2222222222222222222222222222222222222222",
+			InstallTags:    []string{},
+			FileChunkParts: []ManifestFileChunkPart{{GUID: "FEDCBA9876543210FEDCBA9876543210", Offset: "0", Size: "10", SizeInt: 10}},
+		},
+	)
+	parsed, err := parseManifest(encodeBinaryManifest(t, next, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.MarshalEpicJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonManifest, err := parseManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsonManifest.FileManifestList[0].FileHash == binaryManifest.FileManifestList[0].FileHash {
+		t.Fatal("json manifest hashes aren't packed")
+	}
+
+	for _, tt := range []struct {
+		name string
+		a, b *Manifest
+		want *ManifestDiff
+	}{
+		{"binary to json", binaryManifest, jsonManifest, &ManifestDiff{
+			Added:    []string{"FortniteGame/Content/Paks/added.pak"},
+			Removed:  []string{"FortniteGame/Content/Paks/removed.pak"},
+			Modified: []string{"FortniteGame/Content/Paks/pakchunk1.pak"},
+		}},
+		{"json to binary", jsonManifest, binaryManifest, &ManifestDiff{
+			Added:    []string{"FortniteGame/Content/Paks/removed.pak"},
+			Removed:  []string{"FortniteGame/Content/Paks/added.pak"},
+			Modified: []string{"FortniteGame/Content/Paks/pakchunk1.pak"},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffManifests(tt.a, tt.b)
+			if !reflect.DeepEqual(diff.Added, tt.want.Added) || !reflect.DeepEqual(diff.Removed, tt.want.Removed) || !reflect.DeepEqual(diff.Modified, tt.want.Modified) {
+				t.Fatalf("got added %q, removed %q, modified %q", diff.Added, diff.Removed, diff.Modified)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long to wait before retrying a write when the disk is full
+const diskFullRetryInterval = 30 * time.Second
+
+// Check if an error was caused by the disk running out of space
+func isDiskFull(err error) bool {
+	for _, target := range diskFullErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report a full disk and wait for space if enabled, returns true if the write should be retried
+func handleDiskFull(path string, needed int64) bool {
+	errorf("Disk full while writing %s, at least %s more space is needed.\n", path, formatBytes(needed))
+
+	if !waitForSpace || stopRequested() {
+		return false
+	}
+
+	warnf("Waiting %s for space to be freed...\n", diskFullRetryInterval)
+	time.Sleep(diskFullRetryInterval)
+
+	return !stopRequested()
+}
+
+// Check up front that the install volume has room for the download.
+// Files being replaced only need the difference in size, plus room for the largest file's partial copy.
+func checkDiskSpace(files map[string]ManifestFile, chunks map[string]Chunk) error {
+	var needed, largest int64
+	for _, file := range files {
+		size := int64(file.Size())
+		if fi, err := os.Stat(file.FileName); err == nil {
+			size -= fi.Size()
+		}
+		if size > 0 {
+			needed += size
+		}
+
+		if int64(file.Size()) > largest {
+			largest = int64(file.Size())
+		}
+	}
+	needed += largest
+
+	// Saved chunks take space too
+	if saveChunks {
+		for _, chunk := range chunks {
+			needed += chunk.FileSize
+		}
+	}
+
+	// Find the closest existing folder, the install folder may not exist yet
+	path, err := filepath.Abs(installPath)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	available, err := freeSpace(path)
+	if err != nil {
+		warnf("Failed to check free space on %s: %v\n", path, err)
+		return nil
+	}
+
+	if uint64(needed) > available {
+		return fmt.Errorf("not enough free space on %s: %s needed, %s available", path, formatBytes(needed), formatBytes(int64(available)))
+	}
+
+	return nil
+}
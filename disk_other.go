@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+var diskFullErrors = []error{syscall.ENOSPC}
+
+var crossDeviceErrors = []error{syscall.EXDEV}
+
+// Free space available to the user on the volume of path
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
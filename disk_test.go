@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Storage whose files fail every write as if the disk were full
+type fullStorage struct {
+	osStorage
+}
+
+func (s fullStorage) Create(path string, perm os.FileMode) (StorageFile, error) {
+	f, err := s.osStorage.Create(path, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return fullFile{f}, nil
+}
+
+type fullFile struct {
+	StorageFile
+}
+
+// Fail with the platform's disk full error, ENOSPC outside of windows
+func (f fullFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.Name(), Err: diskFullErrors[0]}
+}
+
+func TestDiskFull(t *testing.T) {
+	logs := recordLogs(t)
+	clearStop(t)
+
+	data := testData(1, 4096)
+	guid := testGUID(0)
+	chunks := useTestChunkDir(t, map[string][]byte{guid: makeTestChunk(t, data, storedAsZlib, chunkHeaderSize, 0)})
+
+	previous := storage
+	storage = fullStorage{}
+	t.Cleanup(func() { storage = previous })
+
+	path := filepath.Join(useTestInstallDir(t), "file.bin")
+	file := testFile(path, []string{guid}, [][]byte{data})
+	d := &FileDownload{
+		files:        map[string]ManifestFile{path: file},
+		chunks:       chunks,
+		checkedFiles: make(map[string]ManifestFile),
+		writtenFiles: make(map[string]string),
+	}
+	d.Run([]string{path})
+
+	if !logs.contains("Disk full while writing") {
+		t.Fatal("disk full wasn't reported")
+	}
+	if !stopRequested() {
+		t.Fatal("download didn't stop without -wait-for-space")
+	}
+	if d.failedFiles != 0 {
+		t.Fatalf("disk full counted as %d failed files", d.failedFiles)
+	}
+	if _, err := os.Stat(tempPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file moved into place: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL are returned instead of ENOSPC
+var diskFullErrors = []error{syscall.ENOSPC, syscall.Errno(39), syscall.Errno(112)}
+
+// ERROR_NOT_SAME_DEVICE is returned instead of EXDEV
+var crossDeviceErrors = []error{syscall.EXDEV, syscall.Errno(17)}
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Free space available to the user on the volume of path
+func freeSpace(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	if r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&available)), 0, 0); r == 0 {
+		return 0, err
+	}
+
+	return available, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileDownload holds the state of downloading the files of a run, shared by all manifests with -parallel-manifests
+type FileDownload struct {
+	remainingBytes int64 // accessed atomically, first for 64-bit alignment
+
+	files        map[string]ManifestFile
+	chunks       map[string]Chunk
+	plan         SyncPlan
+	checkedFiles map[string]ManifestFile // files already verified, skipped by the integrity check
+	writtenFiles map[string]string       // by file hash, for -dedup
+	lock         sync.Mutex
+
+	downloadedFiles int
+	failedFiles     int
+	downloadedBytes int64
+	networkBytes    int64
+}
+
+// Run downloads the named files one after another, returning early once a stop is requested
+func (d *FileDownload) Run(names []string) {
+	for _, k := range names {
+		if stopRequested() {
+			return
+		}
+
+		d.downloadFile(k, d.files[k])
+	}
+}
+
+// Download a single file unless it's already on disk or can be linked to an identical one
+func (d *FileDownload) downloadFile(k string, file ManifestFile) {
+	// Check if file already exists, a sync already checked every file
+	if (syncMode && d.plan.Unchanged[k]) || (!syncMode && fileOnDisk(file)) {
+		// Remove any trailing chunks
+		for _, chunkPart := range file.FileChunkParts {
+			chunkCache.Used(chunkPart.GUID)
+		}
+
+		infof("File %s found on disk!\n", file.FileName)
+
+		// Already hashed unless only the size was checked, files are verified at most once per run
+		d.lock.Lock()
+		if !preferLocal || noIntegrityOnExisting {
+			d.checkedFiles[k] = file
+		}
+		if key := dedupKey(file); dedupFiles && key != "" {
+			d.writtenFiles[key] = k
+		}
+		d.lock.Unlock()
+		atomic.AddInt64(&d.remainingBytes, -int64(file.Size()))
+		fileDone(file)
+		return
+	}
+
+	// Link identical files instead of assembling them again
+	if key := dedupKey(file); dedupFiles && key != "" {
+		d.lock.Lock()
+		src, ok := d.writtenFiles[key]
+		d.lock.Unlock()
+		if ok {
+			err := linkFile(src, file.FileName)
+			if err == nil {
+				for _, chunkPart := range file.FileChunkParts {
+					chunkCache.Used(chunkPart.GUID)
+				}
+
+				infof("Linked %s to identical %s.\n", file.FileName, src)
+				d.lock.Lock()
+				if _, ok := d.checkedFiles[src]; ok {
+					d.checkedFiles[k] = file
+				}
+				d.lock.Unlock()
+				atomic.AddInt64(&d.remainingBytes, -int64(file.Size()))
+				fileDone(file)
+				return
+			}
+			debugf("Failed to link %s, assembling instead: %v\n", file.FileName, err)
+		}
+	}
+
+	infof("Downloading %s from %d chunks...\n", file.FileName, len(file.FileChunkParts))
+
+	for {
+		fileStart := time.Now()
+		networkBytes, err := downloadFile(file, d.chunks)
+		if err == nil {
+			elapsed := time.Since(fileStart)
+			infof("Downloaded %s (%s, %s from network) in %s at %s.\n", file.FileName, formatBytes(int64(file.Size())), formatBytes(networkBytes), elapsed.Round(time.Millisecond), formatSpeed(networkBytes, elapsed))
+
+			d.lock.Lock()
+			d.networkBytes += networkBytes
+			d.downloadedBytes += int64(file.Size())
+			d.downloadedFiles++
+
+			// Verified before being moved into place
+			if !skipIntegrityCheck {
+				d.checkedFiles[k] = file
+			}
+			if key := dedupKey(file); dedupFiles && key != "" {
+				d.writtenFiles[key] = k
+			}
+			d.lock.Unlock()
+			fileDone(file)
+			break
+		}
+
+		d.lock.Lock()
+		d.networkBytes += networkBytes
+		d.lock.Unlock()
+
+		if !isDiskFull(err) {
+			errorf("Failed to download %s: %v\n", file.FileName, err)
+			d.lock.Lock()
+			d.failedFiles++
+			d.lock.Unlock()
+			atomic.AddInt64(&progress.filesFailed, 1)
+			break
+		}
+
+		// Let other files in progress finish, main reports the stop
+		if !handleDiskFull(file.FileName, atomic.LoadInt64(&d.remainingBytes)) {
+			errorf("Stopping, run splash again once space has been freed to resume.")
+			requestStop()
+			return
+		}
+	}
+
+	atomic.AddInt64(&d.remainingBytes, -int64(file.Size()))
+}
+
+// Download the files of every manifest as a separate job, running up to -parallel-manifests at once.
+// Files keep their order within each manifest, chunks shared by manifests are still only fetched once.
+func downloadManifestsParallel(d *FileDownload, names []string, fileSources map[string]string) {
+	// Group files by the manifest they come from
+	var sources []string
+	groups := make(map[string][]string)
+	for _, k := range names {
+		source := fileSources[k]
+		if _, ok := groups[source]; !ok {
+			sources = append(sources, source)
+		}
+		groups[source] = append(groups[source], k)
+	}
+
+	infof("Downloading %d manifests, %d at a time.\n", len(sources), parallelManifests)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelManifests)
+	for _, source := range sources {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(source string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			d.Run(groups[source])
+			infof("Finished %s in %s.\n", source, time.Since(start).Round(time.Millisecond))
+		}(source)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Ways a test mirror answers a request
+const (
+	faultNone     = iota
+	faultStatus   // 500 response
+	faultTruncate // connection closed before the announced length
+	faultShort    // complete response of only part of the body
+)
+
+// Mirror serving a build, failing requests as told
+type testMirror struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	files    map[string][]byte // by url path
+	requests map[string]int
+	ranges   []string // range headers of every request, in order
+
+	fault func(path string, n int) int // fault for the nth request of a path
+}
+
+func newTestMirror(t *testing.T, fault func(path string, n int) int) *testMirror {
+	m := &testMirror{
+		files:    make(map[string][]byte),
+		requests: make(map[string]int),
+		fault:    fault,
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *testMirror) serve(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	m.requests[r.URL.Path]++
+	n := m.requests[r.URL.Path]
+	m.ranges = append(m.ranges, r.Header.Get("Range"))
+	data, ok := m.files[r.URL.Path]
+	m.lock.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	fault := faultNone
+	if m.fault != nil {
+		fault = m.fault(r.URL.Path, n)
+	}
+	switch fault {
+	case faultStatus:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	case faultTruncate:
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Write(data[:len(data)/2])
+	case faultShort:
+		w.Write(data[:len(data)/2])
+	default:
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}
+}
+
+// Requests made for a path so far
+func (m *testMirror) requestCount(path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.requests[path]
+}
+
+// A build to download, its manifest, raw chunks and the files they assemble
+type testBuild struct {
+	manifest *Manifest
+	raw      map[string][]byte // by GUID
+	want     map[string][]byte // by file name
+}
+
+// A build without chunks or files yet
+func emptyTestBuild() testBuild {
+	return testBuild{
+		manifest: &Manifest{
+			ManifestFileVersion:  "18",
+			AppNameString:        "Fortnite",
+			BuildVersionString:   "++Fortnite+Release-1.0-CL-1-Windows",
+			PreReqIds:            []string{},
+			ChunkHashList:        make(map[string]string),
+			ChunkShaList:         make(map[string]string),
+			DataGroupList:        make(map[string]string),
+			ChunkFilesizeListInt: make(map[string]uint64),
+			CustomFields:         map[string]string{},
+		},
+		raw:  make(map[string][]byte),
+		want: make(map[string][]byte),
+	}
+}
+
+// Three chunks, two compressed and one stored as is, with the first shared within and between files
+func newTestBuild(t *testing.T) testBuild {
+	b := emptyTestBuild()
+
+	guids := make([]string, 3)
+	data := make([][]byte, 3)
+	for i := range guids {
+		data[i] = testData(byte(i*50), 4096+i*1000)
+
+		storedAs := uint8(storedAsZlib)
+		if i == 2 {
+			storedAs = storedAsPlaintext
+		}
+		guids[i] = b.addChunk(t, data[i], storedAs, chunkHeaderSize)
+	}
+
+	b.addFile("FortniteGame/Content/Paks/pakchunk0.pak", []string{guids[0], guids[1], guids[0]}, [][]byte{data[0], data[1], data[0]})
+	b.addFile("FortniteGame/Content/Paks/pakchunk1.pak", []string{guids[2], guids[0]}, [][]byte{data[2], data[0]})
+
+	return b
+}
+
+// Add a chunk of data to the build, returning its GUID
+func (b *testBuild) addChunk(t *testing.T, data []byte, storedAs uint8, headerSize int) string {
+	i := len(b.raw)
+	guid := testGUID(i)
+	hash := uint64(0x1000 + i)
+	sum := sha1.Sum(data)
+
+	b.raw[guid] = makeTestChunk(t, data, storedAs, headerSize, hash)
+	b.manifest.ChunkHashList[guid] = fmt.Sprintf("%016X", hash)
+	b.manifest.ChunkShaList[guid] = hex.EncodeToString(sum[:])
+	b.manifest.DataGroupList[guid] = fmt.Sprint(i)
+	b.manifest.ChunkFilesizeListInt[guid] = uint64(len(b.raw[guid]))
+
+	return guid
+}
+
+func (b *testBuild) addFile(name string, guids []string, data [][]byte) {
+	file := testFile(name, guids, data)
+	file.InstallTags = []string{}
+	b.manifest.FileManifestList = append(b.manifest.FileManifestList, file)
+	b.want[name] = bytes.Join(data, nil)
+}
+
+// Url path of a chunk, as requested from a mirror
+func (b *testBuild) chunkPath(guid string) string {
+	chunk := b.manifest.GetChunk(guid)
+	return chunk.GetURL("")
+}
+
+// Serve the manifest at /manifest and every chunk at its path
+func (b *testBuild) serve(t *testing.T, m *testMirror) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.files["/manifest"] = encodeBinaryManifest(t, b.manifest, true)
+	for guid, raw := range b.raw {
+		m.files[b.chunkPath(guid)] = raw
+	}
+}
+
+// Fetch the manifest from manifestURL and download all its files from the mirrors into a new install folder
+func downloadTestBuild(t *testing.T, manifestURL string, mirrors ...*testMirror) (*FileDownload, string) {
+	t.Helper()
+
+	previousURLs := downloadURLs
+	downloadURLs = nil
+	for _, m := range mirrors {
+		downloadURLs = append(downloadURLs, m.URL)
+	}
+	t.Cleanup(func() { downloadURLs = previousURLs })
+
+	// Nothing in the chunk folder, everything comes from the mirrors
+	setGlobal(t, &chunkPath, t.TempDir())
+	dir := useTestInstallDir(t)
+
+	manifest, _, err := fetchManifest(manifestURL)
+	if err != nil {
+		t.Fatalf("failed to fetch manifest: %v", err)
+	}
+
+	d := &FileDownload{
+		files:        make(map[string]ManifestFile),
+		chunks:       make(map[string]Chunk),
+		checkedFiles: make(map[string]ManifestFile),
+		writtenFiles: make(map[string]string),
+	}
+	chunkCache.ResetParents()
+	t.Cleanup(chunkCache.ResetParents)
+	var names []string
+	for _, file := range manifest.FileManifestList {
+		for _, part := range file.FileChunkParts {
+			d.chunks[part.GUID] = manifest.GetChunk(part.GUID)
+			chunkCache.AddParents(part.GUID, 1)
+		}
+		file.FileName = filepath.Join(dir, file.FileName)
+		d.files[file.FileName] = file
+		names = append(names, file.FileName)
+	}
+	sort.Strings(names)
+
+	d.Run(names)
+
+	return d, dir
+}
+
+// Check every file of the build was assembled byte for byte
+func (b *testBuild) check(t *testing.T, d *FileDownload, dir string) {
+	t.Helper()
+
+	if d.failedFiles > 0 || d.downloadedFiles != len(b.want) {
+		t.Fatalf("downloaded %d files, %d failed", d.downloadedFiles, d.failedFiles)
+	}
+
+	for name, want := range b.want {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s assembled wrong", name)
+		}
+	}
+}
+
+func TestDownloadFromMirror(t *testing.T) {
+	b := newTestBuild(t)
+	mirror := newTestMirror(t, nil)
+	b.serve(t, mirror)
+
+	cacheBytes := atomic.LoadInt64(&progress.cacheBytes)
+	d, dir := downloadTestBuild(t, mirror.URL+"/manifest", mirror)
+	b.check(t, d, dir)
+
+	// The shared chunk is downloaded once and served from the cache the other two times
+	for guid := range b.raw {
+		if n := mirror.requestCount(b.chunkPath(guid)); n != 1 {
+			t.Errorf("chunk %s requested %d times", guid, n)
+		}
+	}
+	shared := testGUID(0)
+	partSize := int64(b.manifest.FileManifestList[0].FileChunkParts[0].SizeInt)
+	if got := atomic.LoadInt64(&progress.cacheBytes) - cacheBytes; got != 2*partSize {
+		t.Errorf("served %d bytes from cache, want the two later parts of the shared chunk, %d bytes", got, 2*partSize)
+	}
+	if chunkCache.Parents(shared) != 0 {
+		t.Errorf("shared chunk still has %d parents", chunkCache.Parents(shared))
+	}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	b := newTestBuild(t)
+
+	// Every chunk fails every way once before being served
+	faults := []int{faultStatus, faultTruncate, faultShort}
+	mirror := newTestMirror(t, func(path string, n int) int {
+		if path == "/manifest" || n > len(faults) {
+			return faultNone
+		}
+		return faults[n-1]
+	})
+	b.serve(t, mirror)
+
+	logs := recordLogs(t)
+	d, dir := downloadTestBuild(t, mirror.URL+"/manifest", mirror)
+	b.check(t, d, dir)
+
+	for guid := range b.raw {
+		if n := mirror.requestCount(b.chunkPath(guid)); n != len(faults)+1 {
+			t.Errorf("chunk %s requested %d times, want %d", guid, n, len(faults)+1)
+		}
+	}
+	if !logs.contains("invalid status code 500") {
+		t.Error("failed chunk download not reported")
+	}
+}
+
+func TestDownloadFailover(t *testing.T) {
+	b := newTestBuild(t)
+
+	broken := newTestMirror(t, func(path string, n int) int {
+		if strings.HasSuffix(path, ".chunk") {
+			return faultStatus
+		}
+		return faultTruncate
+	})
+	good := newTestMirror(t, nil)
+	b.serve(t, broken)
+	b.serve(t, good)
+
+	recordLogs(t)
+	d, dir := downloadTestBuild(t, good.URL+"/manifest", broken, good)
+	b.check(t, d, dir)
+
+	// A chunk failing on one mirror is fetched from the other next, so once from the good one
+	for guid := range b.raw {
+		if n := good.requestCount(b.chunkPath(guid)); n != 1 {
+			t.Errorf("chunk %s requested %d times from the good mirror", guid, n)
+		}
+	}
+}
+
+func TestFetchManifestResume(t *testing.T) {
+	b := newTestBuild(t)
+	mirror := newTestMirror(t, func(path string, n int) int {
+		if n == 1 {
+			return faultTruncate
+		}
+		return faultNone
+	})
+	b.serve(t, mirror)
+
+	recordLogs(t)
+	manifest, body, err := fetchManifest(mirror.URL + "/manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, mirror.files["/manifest"]) {
+		t.Fatal("resumed manifest body differs")
+	}
+	if len(manifest.FileManifestList) != len(b.want) {
+		t.Fatalf("got %d files", len(manifest.FileManifestList))
+	}
+
+	// The second request continues after what the first one got
+	if len(mirror.ranges) != 2 || mirror.ranges[1] != fmt.Sprintf("bytes=%d-", len(body)/2) {
+		t.Fatalf("got range headers %q", mirror.ranges)
+	}
+}
+
+func TestChunkDownloadTruncated(t *testing.T) {
+	b := newTestBuild(t)
+	guid := testGUID(0)
+
+	for _, fault := range []int{faultStatus, faultTruncate, faultShort} {
+		mirror := newTestMirror(t, func(string, int) int { return fault })
+		b.serve(t, mirror)
+
+		chunk := b.manifest.GetChunk(guid)
+		if data, err := chunk.Download(mirror.URL); err == nil {
+			t.Errorf("fault %d: got %d bytes and no error", fault, len(data))
+		}
+	}
+}
+
+func TestDownloadCachedHeaderSize(t *testing.T) {
+	for _, headerSize := range []int{chunkHeaderSize, chunkHeaderSize + 8} {
+		for _, compressed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("header %d compressed cache %v", headerSize, compressed), func(t *testing.T) {
+				previous := cacheCompressed
+				cacheCompressed = compressed
+				defer func() { cacheCompressed = previous }()
+
+				// A chunk stored as is, cached by the first file and read back by the second
+				b := emptyTestBuild()
+				data := testData(7, 5000)
+				guid := b.addChunk(t, data, storedAsPlaintext, headerSize)
+				b.addFile("first.bin", []string{guid}, [][]byte{data})
+				b.addFile("second.bin", []string{guid}, [][]byte{data})
+
+				mirror := newTestMirror(t, nil)
+				b.serve(t, mirror)
+
+				d, dir := downloadTestBuild(t, mirror.URL+"/manifest", mirror)
+				b.check(t, d, dir)
+				if n := mirror.requestCount(b.chunkPath(guid)); n != 1 {
+					t.Fatalf("chunk requested %d times", n)
+				}
+			})
+		}
+	}
+}
+
+func TestDownloadRangeRequests(t *testing.T) {
+	previous := rangeRequests
+	rangeRequests = true
+	defer func() { rangeRequests = previous }()
+
+	// Random data doesn't compress, so the second chunk is larger than the range of its part
+	random := make([]byte, 8000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	b := emptyTestBuild()
+	small := testData(3, 4096)
+	guids := []string{
+		b.addChunk(t, small, storedAsZlib, chunkHeaderSize),
+		b.addChunk(t, random, storedAsZlib, chunkHeaderSize),
+		b.addChunk(t, random, storedAsPlaintext, chunkHeaderSize),
+	}
+	parts := []ChunkPart{{Offset: 0, Size: 4096}, {Offset: 100, Size: 1000}, {Offset: 2000, Size: 500}}
+	chunkData := [][]byte{small, random, random}
+
+	// One file of a part of every chunk, each chunk used once
+	file := ManifestFile{FileName: "file.bin", InstallTags: []string{}}
+	var want []byte
+	for i, guid := range guids {
+		file.FileChunkParts = append(file.FileChunkParts, ManifestFileChunkPart{GUID: guid, OffsetInt: parts[i].Offset, SizeInt: parts[i].Size})
+		want = append(want, chunkData[i][parts[i].Offset:parts[i].Offset+parts[i].Size]...)
+	}
+	sum := sha1.Sum(want)
+	file.FileHash = hex.EncodeToString(sum[:])
+	b.manifest.FileManifestList = append(b.manifest.FileManifestList, file)
+	b.want[file.FileName] = want
+
+	mirror := newTestMirror(t, nil)
+	b.serve(t, mirror)
+
+	d, dir := downloadTestBuild(t, mirror.URL+"/manifest", mirror)
+	b.check(t, d, dir)
+
+	// The small compressed chunk is fetched whole right away, the large one needs the rest fetched
+	for i, wantRequests := range []int{1, 2, 1} {
+		if n := mirror.requestCount(b.chunkPath(guids[i])); n != wantRequests {
+			t.Errorf("chunk %d requested %d times, want %d", i, n, wantRequests)
+		}
+	}
+
+	// Without a known size, a range covering the whole chunk still counts as all of it
+	chunk := b.manifest.GetChunk(guids[0])
+	chunk.FileSize = 0
+	reader, downloaded, err := chunk.DownloadPart(mirror.URL, parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if got, _ := ioutil.ReadAll(reader); !bytes.Equal(got, small) || downloaded != int64(len(b.raw[guids[0]])) {
+		t.Fatalf("got %d bytes after downloading %d", len(got), downloaded)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Report what a real run would do without creating directories, fetching chunks or writing files
+func reportDryRun(files map[string]ManifestFile, chunks map[string]Chunk) {
+	presentFiles := 0
+	var installBytes uint64
+	neededChunks := make(map[string]Chunk)
+
+	if onlyDLChunks {
+		for guid, chunk := range chunks {
+			neededChunks[guid] = chunk
+		}
+	} else {
+		for _, file := range files {
+			// Check if file already exists
+			if fileOnDisk(file) {
+				presentFiles++
+				continue
+			}
+
+			installBytes += file.Size()
+
+			// Collect unique chunks
+			for _, chunkPart := range file.FileChunkParts {
+				neededChunks[chunkPart.GUID] = chunks[chunkPart.GUID]
+			}
+		}
+	}
+
+	// Skip chunks available on disk
+	var totalBytes int64
+	remoteChunks := 0
+	for guid, chunk := range neededChunks {
+		if fi, err := os.Stat(filepath.Join(chunkPath, guid)); err == nil && (!onlyDLChunks || fi.Size() == chunk.FileSize) {
+			continue
+		}
+
+		remoteChunks++
+		totalBytes += chunk.FileSize
+	}
+
+	infof("Dry run, nothing will be downloaded.")
+	if !onlyDLChunks {
+		infof("Files already present: %d\n", presentFiles)
+		infof("Files to download: %d (%s once installed)\n", len(files)-presentFiles, formatBytes(int64(installBytes)))
+	}
+	infof("Chunks to fetch: %d (%d found in chunk dir)\n", remoteChunks, len(neededChunks)-remoteChunks)
+	infof("Total download size: %s (%d bytes)\n", formatBytes(totalBytes), totalBytes)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Print the header of a chunk, check its hashes and write its data to <guid>.bin, returns the exit code
+func dumpChunk(manifests []*Manifest, guid string) int {
+	guid = strings.ToUpper(guid)
+
+	// Find the chunk in the manifests
+	var chunk Chunk
+	found := false
+	for _, manifest := range manifests {
+		if manifest.HasChunk(guid) {
+			chunk = manifest.GetChunk(guid)
+			found = true
+			break
+		}
+	}
+	if !found {
+		errorf("Chunk %s isn't in the manifest.\n", guid)
+		return exitFatal
+	}
+
+	// Read from the chunk folder or download
+	var raw []byte
+	var err error
+	if chunkPath != "" && chunkOnDisk(chunkPath, guid) {
+		infof("Reading chunk %s from %s...\n", guid, chunkPath)
+		raw, err = ioutil.ReadFile(filepath.Join(chunkPath, guid))
+	} else {
+		downloadURL := pickDownloadURL("")
+		infof("Downloading chunk %s from %s...\n", guid, chunk.GetURL(downloadURL))
+		raw, err = chunk.Download(downloadURL)
+	}
+	if err != nil {
+		errorf("Failed to get chunk %s: %v\n", guid, err)
+		return exitFatal
+	}
+
+	header, err := readChunkHeader(NewByteCloser(raw))
+	if err != nil {
+		errorf("Failed to read header: %v\n", err)
+		return exitFatal
+	}
+
+	fmt.Printf("Size:                 %d bytes (manifest %d)\n", len(raw), chunk.FileSize)
+	fmt.Printf("Magic:                %08X (expected %08X)\n", header.Magic, uint32(chunkMagic))
+	fmt.Printf("Version:              %d\n", header.Version)
+	fmt.Printf("Header size:          %d\n", header.HeaderSize)
+	fmt.Printf("Data size compressed: %d\n", header.DataSizeCompressed)
+	fmt.Printf("GUID:                 %s\n", strings.ToUpper(hex.EncodeToString(header.GUID[:])))
+	fmt.Printf("Rolling hash:         %016X\n", header.RollingHash)
+	fmt.Printf("Stored as:            %d\n", header.StoredAs)
+	fmt.Printf("SHA-1:                %x\n", header.SHAHash)
+	fmt.Printf("Hash type:            %d\n", header.HashType)
+
+	// Decompress without checking, so the data is written even if it's corrupt
+	reader, err := parseChunkStream(NewByteCloser(raw))
+	if err != nil {
+		errorf("Failed to parse chunk: %v\n", err)
+		return exitCorrupt
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		errorf("Failed to decompress chunk: %v\n", err)
+		return exitCorrupt
+	}
+
+	sum := sha1.Sum(data)
+	fmt.Printf("Data:                 %d bytes, SHA-1 %x\n", len(data), sum)
+
+	exitCode := exitOK
+	if header.Version >= chunkHeaderVersionSha && header.HashType&chunkHashSha1 != 0 && sum != header.SHAHash {
+		errorf("Chunk data doesn't match the header SHA-1.\n")
+		exitCode = exitCorrupt
+	}
+	if chunk.Sha != "" && !strings.EqualFold(hex.EncodeToString(sum[:]), chunk.Sha) {
+		errorf("Chunk data doesn't match the manifest SHA-1 %s.\n", strings.ToLower(chunk.Sha))
+		exitCode = exitCorrupt
+	}
+
+	outPath := guid + ".bin"
+	if err := ioutil.WriteFile(outPath, data, 0644); err != nil {
+		errorf("Failed to write chunk data: %v\n", err)
+		return exitFatal
+	}
+	infof("Chunk data written to %s.\n", outPath)
+
+	return exitCode
+}
@@ -2,22 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
-const (
+// Epic service endpoints, variables so they can be pointed at a local server
+var (
 	accountServiceURL  = "https://account-public-service-prod03.ol.epicgames.com"
 	launcherServiceURL = "https://launcher-public-service-prod06.ol.epicgames.com"
+)
 
+const (
 	eglUserAgent   = "UELauncher/14.2.4-22208432+++Portal+Release-Live Windows/10.0.22000.1.256.64bit"
 	eglCredentials = "MzRhMDJjZjhmNDQxNGUyOWIxNTkyMTg3NmRhMzZmOWE6ZGFhZmJjY2M3Mzc3NDUwMzlkZmZlNTNkOTRmYzc2Y2Y="
 )
 
 var bearerToken = ""
+var bearerTokenExpiry time.Time
+
+var errUnauthorized = errors.New("unauthorized")
 
 // Perform OAuth authentication
 func authenticate() (token string, err error) {
@@ -57,17 +64,26 @@ func authenticate() (token string, err error) {
 		return
 	}
 
-	// Set token from response
+	// Set token from response, renewing it a minute early
 	token = respBody["access_token"].(string)
 	bearerToken = token
+	bearerTokenExpiry = time.Time{}
+	if expiresIn, ok := respBody["expires_in"].(float64); ok {
+		bearerTokenExpiry = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+	}
 
 	return
 }
 
+// Fetch the catalog of the live Fortnite build
+func fetchLiveCatalog() ([]byte, error) {
+	return fetchCatalog(platform, "fn", "4fe75bbc5a674f4f9b356b5c90567da5", "Fortnite", "Live")
+}
+
 // Fetch a catalog
 func fetchCatalog(platform string, namespace string, item string, app string, label string) (data []byte, err error) {
-	// Make sure we are authenticated
-	if bearerToken == "" {
+	// Make sure we are authenticated with a token that hasn't expired
+	if bearerToken == "" || (!bearerTokenExpiry.IsZero() && time.Now().After(bearerTokenExpiry)) {
 		// Attempt to authenticate
 		_, err = authenticate()
 		if err != nil {
@@ -78,6 +94,22 @@ func fetchCatalog(platform string, namespace string, item string, app string, la
 	// Build url
 	url := fmt.Sprintf("%s/launcher/api/public/assets/v2/platform/%s/namespace/%s/catalogItem/%s/app/%s/label/%s", launcherServiceURL, platform, namespace, item, app, label)
 
+	data, err = fetchCatalogURL(url)
+	if err != errUnauthorized {
+		return
+	}
+
+	// Token was revoked or expired early, authenticate again once
+	_, err = authenticate()
+	if err != nil {
+		return
+	}
+
+	return fetchCatalogURL(url)
+}
+
+// Fetch a catalog with the current token, errUnauthorized means the token was rejected
+func fetchCatalogURL(url string) (data []byte, err error) {
 	// Create http request
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -96,13 +128,17 @@ func fetchCatalog(platform string, namespace string, item string, app string, la
 	defer resp.Body.Close()
 
 	// Check response code
+	if resp.StatusCode == http.StatusUnauthorized {
+		err = errUnauthorized
+		return
+	}
 	if resp.StatusCode != 200 {
 		err = fmt.Errorf("invalid status code %d", resp.StatusCode)
 		return
 	}
 
 	// Read body
-	data, err = ioutil.ReadAll(resp.Body)
+	data, err = readBody(resp)
 
 	return
 }
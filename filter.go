@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Check if a manifest file is selected by -minimal and -add-tags.
+// -minimal selects core files without install tags, -add-tags files with any of the tags, neither selects everything.
+func matchesInstallTags(file ManifestFile) bool {
+	if !minimalInstall && len(installTagFilter) == 0 {
+		return true
+	}
+
+	if len(file.InstallTags) == 0 {
+		return minimalInstall
+	}
+
+	for _, tag := range file.InstallTags {
+		if installTagFilter[strings.ToLower(tag)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Warn about -add-tags no file in the manifests has
+func warnUnknownTags(manifests []*Manifest) {
+	known := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, file := range manifest.FileManifestList {
+			for _, tag := range file.InstallTags {
+				known[strings.ToLower(tag)] = true
+			}
+		}
+	}
+
+	for tag := range installTagFilter {
+		if !known[tag] {
+			warnf("Install tag %s isn't used by any file in the manifest.\n", tag)
+		}
+	}
+}
+
+// Check if a manifest file name is selected by the -files and -files-prefix filters and not excluded by -exclude-files
+func matchesFilter(fileName string) bool {
+	// Excludes win over includes
+	if fileExcludeFilter[fileName] {
+		return false
+	}
+
+	// No filter selects everything
+	if len(fileFilter) == 0 && len(filePrefixFilter) == 0 {
+		return true
+	}
+
+	if fileFilter[fileName] {
+		return true
+	}
+
+	name := strings.ReplaceAll(fileName, "\\", "/")
+	for _, pattern := range filePrefixFilter {
+		if strings.HasPrefix(name, pattern) {
+			return true
+		}
+
+		// Glob patterns
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
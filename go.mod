@@ -1,3 +1,5 @@
 module github.com/polynite/splash
 
 go 1.15
+
+require github.com/klauspost/compress v1.15.15
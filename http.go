@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var httpClient = &http.Client{}
+
+// Build the http transport from the flags, scaling connection reuse to the amount of workers
+func newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Dial from the chosen address and ip version
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: httpKeepAlive}
+	if httpBindAddress != "" {
+		ip, err := bindIP(httpBindAddress)
+		if err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if network == "tcp" && httpIPVersion != 0 {
+			network = fmt.Sprintf("tcp%d", httpIPVersion)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	// Route through a proxy, http and socks5 proxies are supported
+	if httpProxy != "" {
+		proxyURL, err := url.Parse(httpProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", httpProxy, err)
+		}
+		if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5" {
+			return nil, fmt.Errorf("unsupported proxy scheme %s, use http, https or socks5", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Keep enough idle connections around for every worker to reuse one
+	idleConns := httpIdleConnsPerHost
+	if idleConns <= 0 {
+		idleConns = workerCount
+		if chunkWorkerCount > idleConns {
+			idleConns = chunkWorkerCount
+		}
+	}
+	transport.MaxIdleConnsPerHost = idleConns
+	if transport.MaxIdleConns < idleConns {
+		transport.MaxIdleConns = idleConns
+	}
+
+	transport.MaxConnsPerHost = httpMaxConnsPerHost
+	transport.IdleConnTimeout = httpIdleConnTimeout
+	transport.TLSHandshakeTimeout = httpTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = httpResponseHeaderTimeout
+	transport.DisableKeepAlives = false
+	transport.DisableCompression = !httpCompression
+
+	// An empty TLSNextProto map disables http/2
+	transport.ForceAttemptHTTP2 = httpHTTP2
+	if !httpHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return transport, nil
+}
+
+// Sleep for a random time up to -request-jitter before a chunk request
+func waitRequestJitter() {
+	if requestJitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(requestJitter))))
+	}
+}
+
+// Resolve -bind-address, either an ip or the name of an interface to use the first address of
+func bindIP(address string) (net.IP, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bind address %s: %w", address, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read addresses of %s: %w", address, err)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		// Match the ip version if one was chosen
+		isV4 := ipNet.IP.To4() != nil
+		if (httpIPVersion == 4 && !isV4) || (httpIPVersion == 6 && isV4) {
+			continue
+		}
+
+		return ipNet.IP, nil
+	}
+
+	return nil, fmt.Errorf("interface %s has no usable address", address)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verify all files not already checked against their manifest hashes, returns the files that failed
+func verifyFiles(files map[string]ManifestFile, checkedFiles map[string]ManifestFile) []ManifestFile {
+	infof("Verifying file integrity...")
+	start := time.Now()
+
+	// Collect files not already checked
+	pending := make([]ManifestFile, 0, len(files))
+	for k, file := range files {
+		// Skip prechecked files
+		if _, ok := checkedFiles[k]; ok {
+			continue
+		}
+
+		pending = append(pending, file)
+	}
+
+	failed := make([]ManifestFile, 0)
+	var failedLock sync.Mutex
+
+	parallelFiles(pending, func(file ManifestFile) {
+		if verifyFile(file) {
+			return
+		}
+
+		failedLock.Lock()
+		failed = append(failed, file)
+		failedLock.Unlock()
+	})
+
+	infof("%d of %d files verified in %s.\n", len(files)-len(failed), len(files), time.Since(start).Round(time.Millisecond))
+
+	return failed
+}
+
+// Verify a single file against its manifest hash
+func verifyFile(file ManifestFile) bool {
+	// Open file
+	f, err := storage.Open(file.FileName)
+	if err != nil {
+		errorf("Failed to open %s: %v\n", file.FileName, err)
+		return false
+	}
+
+	// Hash file
+	equal, err := checkFile(f, file)
+	f.Close()
+
+	if err != nil {
+		errorf("Failed to hash %s: %v\n", file.FileName, err)
+		return false
+	}
+
+	if !equal {
+		// Files without install tags are needed by every install
+		if len(file.InstallTags) == 0 {
+			errorf("Core file %s is corrupt!\n", file.FileName)
+		} else {
+			errorf("File %s is corrupt (install tags: %s)\n", file.FileName, strings.Join(file.InstallTags, ", "))
+		}
+		return false
+	}
+
+	return true
+}
+
+// Apply the -on-corrupt policy to files that failed verification, returns the files still corrupt afterwards
+func handleCorruptFiles(files []ManifestFile, manifestChunks map[string]Chunk) []ManifestFile {
+	switch corruptPolicy {
+	case corruptDelete:
+		for _, file := range files {
+			if err := storage.Remove(file.FileName); err != nil && !os.IsNotExist(err) {
+				errorf("Failed to delete %s: %v\n", file.FileName, err)
+			} else {
+				infof("Deleted corrupt file %s.\n", file.FileName)
+			}
+		}
+	case corruptQuarantine:
+		for _, file := range files {
+			if err := storage.Rename(file.FileName, file.FileName+corruptSuffix); err != nil && !os.IsNotExist(err) {
+				errorf("Failed to quarantine %s: %v\n", file.FileName, err)
+			} else if err == nil {
+				infof("Moved corrupt file %s to %s.\n", file.FileName, file.FileName+corruptSuffix)
+			}
+		}
+	case corruptRedownload:
+		for attempt := 1; attempt <= corruptRedownloadAttempts && len(files) > 0 && !stopRequested(); attempt++ {
+			remaining := make([]ManifestFile, 0)
+			for _, file := range files {
+				infof("Redownloading %s (attempt %d of %d)...\n", file.FileName, attempt, corruptRedownloadAttempts)
+
+				// Downloads are verified before being moved into place
+				if _, err := downloadFile(file, manifestChunks); err != nil {
+					errorf("Failed to redownload %s: %v\n", file.FileName, err)
+					remaining = append(remaining, file)
+				}
+			}
+			files = remaining
+		}
+	}
+
+	return files
+}
+
+// CorruptFile defines a file that failed verification in the run summary
+type CorruptFile struct {
+	FileName    string   `json:"fileName"`
+	InstallTags []string `json:"installTags"`
+	Core        bool     `json:"core"` // no install tags, needed by every install
+}
+
+// Describe corrupt files for the run summary
+func corruptFileList(files []ManifestFile) []CorruptFile {
+	list := make([]CorruptFile, len(files))
+	for i, file := range files {
+		list[i] = CorruptFile{FileName: file.FileName, InstallTags: file.InstallTags, Core: len(file.InstallTags) == 0}
+		if list[i].InstallTags == nil {
+			list[i].InstallTags = []string{}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FileName < list[j].FileName })
+	return list
+}
+
+// Corrupt file policies
+const (
+	corruptReport     = "report"     // only log
+	corruptDelete     = "delete"     // delete the file
+	corruptRedownload = "redownload" // download the file again
+	corruptQuarantine = "quarantine" // move the file aside
+)
+
+// Suffix of quarantined files
+const corruptSuffix = ".corrupt"
+
+// Attempts to redownload a corrupt file
+const corruptRedownloadAttempts = 3
+
+// Run fn for every file across the workers
+func parallelFiles(files []ManifestFile, fn func(file ManifestFile)) {
+	// Build job queue
+	jobs := make(chan ManifestFile, len(files))
+	for _, file := range files {
+		jobs <- file
+	}
+	close(jobs)
+
+	// Workers
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				fn(file)
+			}
+		}()
+	}
+
+	// Wait for all goroutines
+	wg.Wait()
+}
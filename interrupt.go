@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Suffix of files still being assembled
+const partialSuffix = ".partial"
+
+// Set once splash should stop after the files in progress, accessed atomically
+var stopping int32
+
+var partialFiles = make(map[string]bool)
+var partialFilesLock sync.Mutex
+
+// Stop gracefully on the first interrupt, letting in-progress files finish, and abort on the second
+func handleInterrupts() {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		warnf("Shutting down, finishing in-progress files. Interrupt again to abort.")
+		requestStop()
+
+		<-c
+		warnf("Aborting...")
+		removePartials()
+		os.Exit(exitFatal)
+	}()
+}
+
+// Ask every download to stop once the files in progress are done
+func requestStop() {
+	atomic.StoreInt32(&stopping, 1)
+}
+
+// Check if a stop was asked for
+func stopRequested() bool {
+	return atomic.LoadInt32(&stopping) != 0
+}
+
+// Track a file being assembled so it can be cleaned up on abort
+func trackPartial(path string) {
+	partialFilesLock.Lock()
+	partialFiles[path] = true
+	partialFilesLock.Unlock()
+}
+
+func untrackPartial(path string) {
+	partialFilesLock.Lock()
+	delete(partialFiles, path)
+	partialFilesLock.Unlock()
+}
+
+// Remove all files still being assembled
+func removePartials() {
+	partialFilesLock.Lock()
+	defer partialFilesLock.Unlock()
+
+	for path := range partialFiles {
+		storage.Remove(path)
+	}
+}
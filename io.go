@@ -2,9 +2,24 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 )
 
+// Reusable buffers for decompressed chunk data
+var chunkBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 type ReadSeekCloser interface {
 	io.Reader
 	io.Seeker
@@ -30,3 +45,138 @@ func (bc ByteCloser) Close() error {
 func NewByteCloser(data []byte) ByteCloser {
 	return ByteCloser{bytes.NewReader(data)}
 }
+
+// PooledByteCloser reads from a pooled buffer and returns it to the pool when closed
+type PooledByteCloser struct {
+	ByteCloser
+	buf *bytes.Buffer
+}
+
+func (pc *PooledByteCloser) Close() error {
+	if pc.buf != nil {
+		pc.buf.Reset()
+		chunkBufferPool.Put(pc.buf)
+		pc.buf = nil
+	}
+
+	return nil
+}
+
+func NewPooledByteCloser(buf *bytes.Buffer) *PooledByteCloser {
+	return &PooledByteCloser{NewByteCloser(buf.Bytes()), buf}
+}
+
+// StreamReader reads data as it's decompressed, it can only seek forwards
+type StreamReader struct {
+	r   io.ReadCloser
+	src io.Closer
+	pos int64
+}
+
+func (sr *StreamReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.pos += int64(n)
+	return n, err
+}
+
+// Seek skips forward by reading and discarding data
+func (sr *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	target := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		target += sr.pos
+	default:
+		return sr.pos, fmt.Errorf("can't seek from the end of a stream")
+	}
+
+	if target < sr.pos {
+		return sr.pos, fmt.Errorf("can't seek backwards in a stream, from %d to %d", sr.pos, target)
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, sr, target-sr.pos); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return sr.pos, err
+	}
+
+	return sr.pos, nil
+}
+
+func (sr *StreamReader) Close() error {
+	err := sr.r.Close()
+	sr.src.Close()
+	return err
+}
+
+// NewStreamReader reads from r, closing src as well when closed
+func NewStreamReader(r io.ReadCloser, src io.Closer) *StreamReader {
+	return &StreamReader{r: r, src: src}
+}
+
+// OffsetWriter writes sequentially to an io.WriterAt from an offset
+type OffsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *OffsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
+
+func NewOffsetWriter(w io.WriterAt, off int64) *OffsetWriter {
+	return &OffsetWriter{w, off}
+}
+
+// Write a file to its temp path and move it into place once complete,
+// so a crash or interrupt never leaves a partial file under the real name
+func writeFileAtomic(path string, data []byte) error {
+	partialPath := tempPath(path)
+	trackPartial(partialPath)
+	defer untrackPartial(partialPath)
+
+	if err := ioutil.WriteFile(partialPath, data, 0644); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	if err := moveFile(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	return nil
+}
+
+// Read a response body, decoding it if the transport didn't already.
+// Go only decompresses gzip transparently when it requested it itself.
+func readBody(resp *http.Response) ([]byte, error) {
+	var reader io.Reader = resp.Body
+
+	if !resp.Uncompressed {
+		switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+		case "", "identity":
+		case "gzip":
+			gzipReader, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create decompressor: %v", err)
+			}
+			defer gzipReader.Close()
+			reader = gzipReader
+		case "deflate":
+			zlibReader, err := zlib.NewReader(resp.Body)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create decompressor: %v", err)
+			}
+			defer zlibReader.Close()
+			reader = zlibReader
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+		}
+	}
+
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Output layouts
+const (
+	layoutVersioned = "versioned" // install-dir/<build version>/<file>
+	layoutFlat      = "flat"      // install-dir/<file>
+	layoutNone      = "none"      // <file>
+)
+
+// Build the output path of a manifest file for a layout
+func outputPath(layout string, installDir string, buildVersion string, fileName string) string {
+	switch layout {
+	case layoutFlat:
+		return filepath.Join(installDir, fileName)
+	case layoutNone:
+		return filepath.FromSlash(fileName)
+	}
+
+	return filepath.Join(installDir, strings.TrimSuffix(strings.TrimPrefix(buildVersion, "++Fortnite+Release-"), "-"+platform), fileName)
+}
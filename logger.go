@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Log levels
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// Logger receives log output, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var logger Logger = log.New(os.Stderr, "", log.LstdFlags)
+var logLevel = levelInfo
+
+// Parse a log level name
+func parseLogLevel(name string) (int, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %s", name)
+	}
+
+	return level, nil
+}
+
+func logf(level int, format string, v ...interface{}) {
+	if level >= logLevel {
+		logger.Printf(format, v...)
+	}
+
+	// Keep problems around for the status endpoint
+	if level >= levelWarn && statusAddr != "" {
+		recordRecentError(fmt.Sprintf(format, v...))
+	}
+}
+
+func debugf(format string, v ...interface{}) {
+	logf(levelDebug, format, v...)
+}
+
+func infof(format string, v ...interface{}) {
+	logf(levelInfo, format, v...)
+}
+
+func warnf(format string, v ...interface{}) {
+	logf(levelWarn, format, v...)
+}
+
+func errorf(format string, v ...interface{}) {
+	logf(levelError, format, v...)
+}
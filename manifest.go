@@ -1,7 +1,9 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"compress/zlib"
 	"crypto/sha1"
 	"encoding/binary"
@@ -11,11 +13,30 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Manifest feature levels that changed the chunk folder
+const (
+	featureDataFileRenames                              = 3
+	featureChunkCompressionSupport                      = 6
+	featureVariableSizeChunksWithoutWindowSizeChunkInfo = 15
+)
+
+// Magic of binary manifests
+const binaryManifestMagic = 0x44BEC00C
+
+// Start of gzip data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Chunk folders by chunk folder version
+var chunkSubdirs = []string{"Chunks", "ChunksV2", "ChunksV3", "ChunksV4"}
+
 // ManifestFileChunkPart defines a chunk part within a ManifestFileChunk
 type ManifestFileChunkPart struct {
 	GUID   string `json:"Guid"`
@@ -32,6 +53,8 @@ type ManifestFile struct {
 	FileHash       string                  `json:"FileHash"`
 	FileChunkParts []ManifestFileChunkPart `json:"FileChunkParts"`
 	InstallTags    []string                `json:"InstallTags"`
+
+	Executable bool `json:"-"` // the manifest's launch executable
 }
 
 // Manifest defines a manifest
@@ -53,7 +76,202 @@ type Manifest struct {
 	DataGroupList        map[string]string `json:"DataGroupList"`
 	ChunkFilesizeList    map[string]string `json:"ChunkFilesizeList"`
 	ChunkFilesizeListInt map[string]uint64 `json:"-"`
-	CustomFields         struct{}          `json:"CustomFields"`
+	CustomFields         map[string]string `json:"CustomFields"`
+}
+
+// Size returns the assembled size of the file
+func (f *ManifestFile) Size() uint64 {
+	var size uint64
+	for _, chunk := range f.FileChunkParts {
+		if chunk.SizeInt != 0 {
+			size += uint64(chunk.SizeInt)
+		} else {
+			size += uint64(readPackedUint32(chunk.Size))
+		}
+	}
+
+	return size
+}
+
+// Hash returns the expected SHA-1 of the file.
+// Binary manifests are parsed into 40 hex characters, json manifests store it packed as 3 decimal digits per byte.
+func (f *ManifestFile) Hash() ([]byte, error) {
+	switch len(f.FileHash) {
+	case sha1.Size * 2:
+		hash, err := hex.DecodeString(f.FileHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex hash %q: %v", f.FileHash, err)
+		}
+		return hash, nil
+	case sha1.Size * 3:
+		// Packing back catches digits outside of a byte
+		hash := readPackedData(f.FileHash)
+		if len(hash) != sha1.Size || writePackedData(hash) != f.FileHash {
+			return nil, fmt.Errorf("invalid packed hash %q", f.FileHash)
+		}
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("unexpected hash %q of length %d", f.FileHash, len(f.FileHash))
+}
+
+// TotalInstallSize returns the size of all files once assembled
+func (m *Manifest) TotalInstallSize() uint64 {
+	var size uint64
+	for _, file := range m.FileManifestList {
+		size += file.Size()
+	}
+
+	return size
+}
+
+// TotalDownloadSize returns the size of all unique chunks, skipping chunks present in chunkDir if it's set
+func (m *Manifest) TotalDownloadSize(chunkDir string) int64 {
+	var size int64
+	seen := make(map[string]bool)
+	for _, file := range m.FileManifestList {
+		for _, c := range file.FileChunkParts {
+			if seen[c.GUID] {
+				continue
+			}
+			seen[c.GUID] = true
+
+			if chunkDir != "" && chunkOnDisk(chunkDir, c.GUID) {
+				continue
+			}
+
+			size += m.GetChunk(c.GUID).FileSize
+		}
+	}
+
+	return size
+}
+
+// FeatureLevel returns the manifest format version, or -1 if unknown.
+// JSON manifests store it packed, binary manifests as a plain number.
+func (m *Manifest) FeatureLevel() int {
+	if len(m.ManifestFileVersion) == 12 {
+		if packed := readPackedData(m.ManifestFileVersion); len(packed) == 4 {
+			return int(readPackedUint32(m.ManifestFileVersion))
+		}
+	}
+
+	if version, err := strconv.Atoi(m.ManifestFileVersion); err == nil {
+		return version
+	}
+
+	return -1
+}
+
+// ChunkSubdir returns the cloud folder chunks of this manifest are stored in
+func (m *Manifest) ChunkSubdir() string {
+	if chunkDirVersion > 0 {
+		return chunkSubdirs[chunkDirVersion-1]
+	}
+
+	level := m.FeatureLevel()
+	switch {
+	case level < 0:
+		return chunkSubdirs[2] // assume ChunksV3 if unknown
+	case level < featureDataFileRenames:
+		return chunkSubdirs[0]
+	case level < featureChunkCompressionSupport:
+		return chunkSubdirs[1]
+	case level < featureVariableSizeChunksWithoutWindowSizeChunkInfo:
+		return chunkSubdirs[2]
+	}
+
+	return chunkSubdirs[3]
+}
+
+// GetChunk builds the chunk with the given GUID from the manifest's chunk lists
+func (m *Manifest) GetChunk(guid string) Chunk {
+	var chunk Chunk
+
+	// Binary manifests store sizes as integers and hashes unpacked
+	if size, ok := m.ChunkFilesizeListInt[guid]; ok {
+		chunk = NewChunkInt(guid, m.ChunkHashList[guid], m.ChunkShaList[guid], m.DataGroupList[guid], size)
+	} else {
+		chunk = NewChunk(guid, m.ChunkHashList[guid], m.ChunkShaList[guid], m.DataGroupList[guid], m.ChunkFilesizeList[guid])
+	}
+	chunk.Subdir = m.ChunkSubdir()
+
+	return chunk
+}
+
+// HasChunk checks if the manifest lists a chunk with the given GUID in every list needed to download it.
+// The SHA-1 is optional, chunks without one just aren't verified.
+func (m *Manifest) HasChunk(guid string) bool {
+	if _, ok := m.ChunkHashList[guid]; !ok {
+		return false
+	}
+	if _, ok := m.DataGroupList[guid]; !ok {
+		return false
+	}
+	if _, ok := m.ChunkFilesizeListInt[guid]; ok {
+		return true
+	}
+	_, ok := m.ChunkFilesizeList[guid]
+	return ok
+}
+
+// VerifyChunkFile checks a chunk file on disk against the SHA-1 the manifest expects for it.
+// The GUID is taken from the file name, which is either "<guid>" or "<hash>_<guid>.chunk".
+func (m *Manifest) VerifyChunkFile(path string) error {
+	guid := strings.ToUpper(strings.TrimSuffix(filepath.Base(path), ".chunk"))
+	if i := strings.LastIndex(guid, "_"); i != -1 {
+		guid = guid[i+1:]
+	}
+
+	if !m.HasChunk(guid) {
+		return fmt.Errorf("chunk %s not in manifest", guid)
+	}
+
+	chunk := m.GetChunk(guid)
+	return chunk.VerifyFile(path)
+}
+
+// Build the urls of a manifest in the archive mirrors, the templates take either a %s or {id} and {platform} placeholders
+func manifestURLs(id string) []string {
+	var urls []string
+	for _, template := range strings.Split(manifestURLTemplate, ",") {
+		if strings.Contains(template, "%s") {
+			urls = append(urls, fmt.Sprintf(template, id))
+		} else {
+			urls = append(urls, strings.NewReplacer("{id}", id, "{platform}", platform).Replace(template))
+		}
+	}
+
+	return urls
+}
+
+// Fetch a manifest from the first of the urls that returns a valid one
+func fetchManifestMirrors(urls []string) (manifest *Manifest, body []byte, err error) {
+	for i, url := range urls {
+		manifest, body, err = fetchManifest(url)
+		if err == nil {
+			return
+		}
+
+		if i < len(urls)-1 {
+			warnf("Failed to fetch manifest from %s: %v, trying the next mirror...\n", url, err)
+		}
+	}
+
+	return
+}
+
+// Load manifest from a file on disk, or fetch it by id if no such file exists
+func loadManifest(source string) (*Manifest, error) {
+	if source == "-" {
+		return readManifest(os.Stdin)
+	}
+
+	if _, err := os.Stat(source); err == nil {
+		return readManifestFile(source)
+	}
+
+	return fetchManifestByID(source)
 }
 
 // Load manifest from a file on disk
@@ -65,57 +283,286 @@ func readManifestFile(filename string) (*Manifest, error) {
 	}
 	defer file.Close()
 
-	fileData, err := ioutil.ReadAll(file)
+	return readManifest(file)
+}
+
+// Load manifest from a reader, such as stdin
+func readManifest(r io.Reader) (*Manifest, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(data)
+}
+
+// Load every manifest in a zip archive
+func readManifestArchive(filename string) ([]*Manifest, error) {
+	archive, err := zip.OpenReader(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer archive.Close()
+
+	manifests := make([]*Manifest, 0, len(archive.File))
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || entry.UncompressedSize64 == 0 {
+			continue
+		}
+
+		r, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+
+		manifest, err := readManifest(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+		}
+
+		manifests = append(manifests, manifest)
+	}
 
-	return parseManifest(fileData)
+	return manifests, nil
 }
 
 // Fetch manifest from a url
 func fetchManifest(url string) (manifest *Manifest, body []byte, err error) {
-	// Get manifest
-	resp, err := httpClient.Get(url)
+	// Get manifest, resuming if the connection drops
+	var retry bool
+	for attempt := 0; attempt < manifestDownloadAttempts; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<uint(attempt-1)) * time.Second
+			warnf("Failed to fetch manifest: %v, retrying in %s from byte %d...\n", err, delay, len(body))
+			time.Sleep(delay)
+		}
+
+		body, retry, err = fetchManifestFrom(url, body)
+		if err == nil || !retry {
+			break
+		}
+	}
 	if err != nil {
 		return
 	}
+
+	// Parse manifest
+	manifest, err = parseManifest(body)
+	return
+}
+
+// Fetch the rest of a manifest after the bytes already received.
+// Returns everything received so far, and whether a failure is worth retrying.
+func fetchManifestFrom(url string, received []byte) ([]byte, bool, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	setMirrorAuth(req)
+
+	// Byte ranges only line up with the raw body
+	if len(received) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(received)))
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return received, true, err
+	}
 	defer resp.Body.Close()
 
 	// Check response code
-	if resp.StatusCode != 200 {
-		err = fmt.Errorf("invalid status code %d", resp.StatusCode)
-		return
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		received = received[:0] // range ignored, starting over
+	case resp.StatusCode == http.StatusPartialContent && len(received) > 0:
+	default:
+		return received, resp.StatusCode >= 500, fmt.Errorf("invalid status code %d", resp.StatusCode)
 	}
 
-	// Read body
-	body, err = ioutil.ReadAll(resp.Body)
+	// Compressed bodies can't be resumed, start over if they fail
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if resp.Uncompressed || (encoding != "" && encoding != "identity") {
+		body, err := readBody(resp)
+		if err != nil {
+			return nil, true, err
+		}
+		return body, false, nil
+	}
+
+	// Keep what arrived before an error
+	body, err := ioutil.ReadAll(resp.Body)
+	received = append(received, body...)
 	if err != nil {
-		return
+		return received, true, err
 	}
 
-	// Parse manifest
-	manifest, err = parseManifest(body)
-	return
+	return received, false, nil
+}
+
+// Fetch the manifest of a catalog element, verifying it against the element's hash.
+// With -refetch-on-hash-mismatch a mismatching manifest is fetched again, trying every manifest url in turn.
+func fetchCatalogManifest(element *CatalogElement) (*Manifest, error) {
+	urls := element.GetManifestURLs()
+	if len(urls) == 0 {
+		return nil, errors.New("no usable manifest url")
+	}
+
+	attempts := 1
+	if refetchOnHashMismatch {
+		attempts = manifestFetchAttempts
+		if attempts < len(urls) {
+			attempts = len(urls)
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		url := urls[attempt%len(urls)]
+
+		var manifest *Manifest
+		var body []byte
+		manifest, body, err = fetchManifest(url)
+		if err != nil {
+			return nil, err
+		}
+
+		// Older catalogs don't carry a hash
+		if element.Hash == "" {
+			return manifest, nil
+		}
+
+		sum := sha1.Sum(body)
+		if strings.EqualFold(hex.EncodeToString(sum[:]), element.Hash) {
+			return manifest, nil
+		}
+
+		err = fmt.Errorf("hash mismatch, expected %s got %x", strings.ToLower(element.Hash), sum)
+		if attempt+1 < attempts {
+			warnf("Manifest from %s failed verification, fetching again...\n", url)
+		}
+	}
+
+	return nil, err
+}
+
+// Convert a manifest to Epic's JSON manifest format, where numbers and hashes are stored as packed strings
+func (m *Manifest) MarshalEpicJSON() ([]byte, error) {
+	out := *m
+
+	// JSON manifests are already packed
+	if m.ChunkFilesizeListInt != nil {
+		level := m.FeatureLevel()
+		if level < 0 {
+			level = 0
+		}
+		out.ManifestFileVersion = writePackedUint32(uint32(level))
+
+		out.FileManifestList = make([]ManifestFile, len(m.FileManifestList))
+		for i, file := range m.FileManifestList {
+			hash, err := hex.DecodeString(file.FileHash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash for %s: %v", file.FileName, err)
+			}
+
+			out.FileManifestList[i] = file
+			out.FileManifestList[i].FileHash = writePackedData(hash)
+			out.FileManifestList[i].FileChunkParts = make([]ManifestFileChunkPart, len(file.FileChunkParts))
+			for j, part := range file.FileChunkParts {
+				out.FileManifestList[i].FileChunkParts[j] = ManifestFileChunkPart{
+					GUID:   part.GUID,
+					Offset: writePackedUint32(part.OffsetInt),
+					Size:   writePackedUint32(part.SizeInt),
+				}
+			}
+		}
+
+		// Json manifests pack the rolling hash little endian
+		out.ChunkHashList = make(map[string]string, len(m.ChunkHashList))
+		for guid, hash := range m.ChunkHashList {
+			data, err := hex.DecodeString(hash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash for chunk %s: %v", guid, err)
+			}
+			reverse(data)
+			out.ChunkHashList[guid] = writePackedData(data)
+		}
+
+		out.DataGroupList = make(map[string]string, len(m.DataGroupList))
+		for guid, group := range m.DataGroupList {
+			n, err := strconv.Atoi(group)
+			if err != nil {
+				return nil, fmt.Errorf("invalid data group for chunk %s: %v", guid, err)
+			}
+			out.DataGroupList[guid] = fmt.Sprintf("%03d", n)
+		}
+
+		out.ChunkFilesizeList = make(map[string]string, len(m.ChunkFilesizeListInt))
+		for guid, size := range m.ChunkFilesizeListInt {
+			out.ChunkFilesizeList[guid] = writePackedUint64(size)
+		}
+	}
+
+	if out.AppID == "" {
+		out.AppID = writePackedUint32(0)
+	}
+	if out.PreReqIds == nil {
+		out.PreReqIds = make([]string, 0)
+	}
+	if out.CustomFields == nil {
+		out.CustomFields = make(map[string]string)
+	}
+
+	return json.MarshalIndent(out, "", "\t")
 }
 
 func parseManifest(data []byte) (manifest *Manifest, err error) {
-	// Parse as json
-	if data[0] == '{' {
-		err = json.Unmarshal(data, manifest)
+	if len(data) == 0 {
+		err = errors.New("empty manifest")
 		return
 	}
 
-	buffer := make([]byte, 4)
-	reader := bytes.NewReader(data)
+	// Archived manifests are often gzipped, either format may be inside
+	if bytes.HasPrefix(data, gzipMagic) {
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			err = fmt.Errorf("failed to decompress gzipped manifest: %v", err)
+			return
+		}
+		defer gzipReader.Close()
 
-	reader.Read(buffer)
-	magic := binary.LittleEndian.Uint32(buffer)
-	if magic != 0x44BEC00C {
-		err = fmt.Errorf("read invalid magic %d", magic)
+		data, err = ioutil.ReadAll(gzipReader)
+		if err != nil {
+			err = fmt.Errorf("failed to decompress gzipped manifest: %v", err)
+			return
+		}
+		if bytes.HasPrefix(data, gzipMagic) {
+			err = errors.New("gzipped manifest is gzipped again")
+			return
+		}
+
+		return parseManifest(data)
+	}
+
+	// Parse as json, which may start with a byte order mark or whitespace
+	if text := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n"); len(text) > 0 && text[0] == '{' {
+		manifest = new(Manifest)
+		err = json.Unmarshal(text, manifest)
 		return
 	}
 
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != binaryManifestMagic {
+		err = errors.New("unrecognized manifest format, neither json nor binary")
+		return
+	}
+
+	buffer := make([]byte, 4)
+	reader := bytes.NewReader(data)
+	reader.Seek(4, io.SeekStart)
+
 	reader.Read(buffer)
 	headerSize := binary.LittleEndian.Uint32(buffer)
 
@@ -131,7 +578,7 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 	format, _ := reader.ReadByte()
 
 	reader.Read(buffer)
-	//version := binary.LittleEndian.Uint32(buffer)
+	version := binary.LittleEndian.Uint32(buffer)
 
 	if reader.Size()-int64(reader.Len()) != int64(headerSize) {
 		err = errors.New("invalid header")
@@ -173,6 +620,7 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 	reader.Seek(14, io.SeekCurrent)
 
 	manifest = new(Manifest)
+	manifest.ManifestFileVersion = strconv.FormatUint(uint64(version), 10)
 	manifest.ChunkHashList = make(map[string]string)
 	manifest.ChunkShaList = make(map[string]string)
 	manifest.DataGroupList = make(map[string]string)
@@ -183,11 +631,17 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 	manifest.LaunchExeString = readString(reader)
 	manifest.LaunchCommand = readString(reader)
 
+	// Prerequisite ids, [u32 count][string 0][...]
 	reader.Read(buffer)
-	if binary.LittleEndian.Uint32(buffer) != 0x00 {
-		err = errors.New("fixme: read arrays") // likely [u32 size][element 0][...]
+	prereqCount := binary.LittleEndian.Uint32(buffer)
+	if int64(prereqCount)*4 > int64(reader.Len()) {
+		err = errors.New("invalid prerequisite id count")
 		return
 	}
+	manifest.PreReqIds = make([]string, prereqCount)
+	for i := range manifest.PreReqIds {
+		manifest.PreReqIds[i] = readString(reader)
+	}
 
 	manifest.PreReqName = readString(reader)
 	manifest.PreReqPath = readString(reader)
@@ -210,7 +664,7 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 	hashBuffer := make([]byte, 8)
 	for i := 0; i < int(chunkSize); i++ {
 		reader.Read(hashBuffer)
-		manifest.ChunkHashList[guids[i]] = strings.ToUpper(hex.EncodeToString(hashBuffer))
+		manifest.ChunkHashList[guids[i]] = fmt.Sprintf("%016X", binary.LittleEndian.Uint64(hashBuffer))
 	}
 
 	shaBuffer := make([]byte, 20)
@@ -233,7 +687,10 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 	}
 
 	// files
-	reader.Seek(5, io.SeekCurrent)
+	fileListStart, _ := reader.Seek(0, io.SeekCurrent)
+	reader.Read(buffer)
+	fileListSize := binary.LittleEndian.Uint32(buffer)
+	reader.Seek(1, io.SeekCurrent)
 
 	reader.Read(buffer)
 	fileSize := binary.LittleEndian.Uint32(buffer)
@@ -288,6 +745,32 @@ func parseManifest(data []byte) (manifest *Manifest, err error) {
 		}
 	}
 
+	// custom fields, [u32 size][u8 version][u32 count][keys][values]
+	if fileListSize > 0 {
+		reader.Seek(fileListStart+int64(fileListSize), io.SeekStart)
+	}
+	if reader.Len() < 9 {
+		return
+	}
+	reader.Seek(5, io.SeekCurrent)
+
+	reader.Read(buffer)
+	fieldCount := binary.LittleEndian.Uint32(buffer)
+	if int64(fieldCount)*8 > int64(reader.Len()) {
+		err = errors.New("invalid custom field count")
+		return
+	}
+
+	keys := make([]string, fieldCount)
+	for i := range keys {
+		keys[i] = readString(reader)
+	}
+
+	manifest.CustomFields = make(map[string]string, fieldCount)
+	for _, key := range keys {
+		manifest.CustomFields[key] = readString(reader)
+	}
+
 	return
 }
 
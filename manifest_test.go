@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Writer for the binary manifest format parseManifest reads
+type binaryWriter struct {
+	bytes.Buffer
+}
+
+func (w *binaryWriter) uint32(n uint32) {
+	binary.Write(w, binary.LittleEndian, n)
+}
+
+func (w *binaryWriter) string(s string) {
+	if s == "" {
+		w.uint32(0)
+		return
+	}
+	w.uint32(uint32(len(s) + 1))
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *binaryWriter) hex(t *testing.T, s string, size int) {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != size {
+		t.Fatalf("invalid hex %q for %d bytes", s, size)
+	}
+	w.Write(data)
+}
+
+// Encode a manifest in the binary format, chunk hashes as big endian hex and file hashes as hex like parseManifest returns them
+func encodeBinaryManifest(t *testing.T, m *Manifest, compressed bool) []byte {
+	t.Helper()
+
+	guids := make([]string, 0, len(m.ChunkHashList))
+	for guid := range m.ChunkHashList {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+
+	var body binaryWriter
+
+	// Meta, the fields before the app name are skipped
+	body.Write(make([]byte, 14))
+	body.string(m.AppNameString)
+	body.string(m.BuildVersionString)
+	body.string(m.LaunchExeString)
+	body.string(m.LaunchCommand)
+	body.uint32(uint32(len(m.PreReqIds)))
+	for _, id := range m.PreReqIds {
+		body.string(id)
+	}
+	body.string(m.PreReqName)
+	body.string(m.PreReqPath)
+	body.string(m.PreReqArgs)
+
+	// Chunks
+	body.Write(make([]byte, 5))
+	body.uint32(uint32(len(guids)))
+	for _, guid := range guids {
+		body.hex(t, guid, 16)
+	}
+	for _, guid := range guids {
+		hash, err := strconv.ParseUint(m.ChunkHashList[guid], 16, 64)
+		if err != nil {
+			t.Fatalf("invalid chunk hash %q", m.ChunkHashList[guid])
+		}
+		binary.Write(&body, binary.LittleEndian, hash)
+	}
+	for _, guid := range guids {
+		body.hex(t, m.ChunkShaList[guid], sha1.Size)
+	}
+	for _, guid := range guids {
+		group, _ := strconv.Atoi(m.DataGroupList[guid])
+		body.WriteByte(byte(group))
+	}
+	body.Write(make([]byte, 4*len(guids)))
+	for _, guid := range guids {
+		binary.Write(&body, binary.LittleEndian, m.ChunkFilesizeListInt[guid])
+	}
+
+	// Files, the list size is left 0 so custom fields follow right after
+	body.uint32(0)
+	body.WriteByte(0)
+	body.uint32(uint32(len(m.FileManifestList)))
+	for _, file := range m.FileManifestList {
+		body.string(file.FileName)
+	}
+	for range m.FileManifestList {
+		body.string("")
+	}
+	for _, file := range m.FileManifestList {
+		body.hex(t, file.FileHash, sha1.Size)
+	}
+	body.Write(make([]byte, len(m.FileManifestList)))
+	for _, file := range m.FileManifestList {
+		body.uint32(uint32(len(file.InstallTags)))
+		for _, tag := range file.InstallTags {
+			body.string(tag)
+		}
+	}
+	for _, file := range m.FileManifestList {
+		body.uint32(uint32(len(file.FileChunkParts)))
+		for _, part := range file.FileChunkParts {
+			body.uint32(28)
+			body.hex(t, part.GUID, 16)
+			body.uint32(part.OffsetInt)
+			body.uint32(part.SizeInt)
+		}
+	}
+
+	// Custom fields
+	keys := make([]string, 0, len(m.CustomFields))
+	for key := range m.CustomFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	body.Write(make([]byte, 5))
+	body.uint32(uint32(len(keys)))
+	for _, key := range keys {
+		body.string(key)
+	}
+	for _, key := range keys {
+		body.string(m.CustomFields[key])
+	}
+
+	data := body.Bytes()
+	stored := data
+	format := byte(0)
+	if compressed {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		stored = buf.Bytes()
+		format = 1
+	}
+
+	var out binaryWriter
+	out.uint32(binaryManifestMagic)
+	out.uint32(41)
+	out.uint32(uint32(len(data)))
+	out.uint32(uint32(len(stored)))
+	sum := sha1.Sum(data)
+	out.Write(sum[:])
+	out.WriteByte(format)
+	out.uint32(18)
+	out.Write(stored)
+
+	return out.Bytes()
+}
+
+// A manifest with one file made of parts of two chunks, as parseManifest returns binary manifests
+func testBinaryManifest() *Manifest {
+	return &Manifest{
+		ManifestFileVersion: "18",
+		AppNameString:       "Fortnite",
+		BuildVersionString:  "++Fortnite+Release-1.0-CL-1-Windows",
+		LaunchExeString:     "FortniteGame/Binaries/Win64/FortniteClient-Win64-Shipping.exe",
+		LaunchCommand:       "-epicapp",
+		PreReqIds:           []string{},
+		FileManifestList: []ManifestFile{
+			{
+				FileName:    "FortniteGame/Content/Paks/pakchunk0.pak",
+				FileHash:    "00112233445566778899aabbccddeeff00112233",
+				InstallTags: []string{},
+				FileChunkParts: []ManifestFileChunkPart{
+					{GUID: "0123456789ABCDEF0123456789ABCDEF", Offset: "0", Size: "100", OffsetInt: 0, SizeInt: 100},
+					{GUID: "FEDCBA9876543210FEDCBA9876543210", Offset: "10", Size: "50", OffsetInt: 10, SizeInt: 50},
+				},
+			},
+		},
+		ChunkHashList: map[string]string{
+			"0123456789ABCDEF0123456789ABCDEF": "0123456789ABCDEF",
+			"FEDCBA9876543210FEDCBA9876543210": "00000000DEADBEEF",
+		},
+		ChunkShaList: map[string]string{
+			"0123456789ABCDEF0123456789ABCDEF": "0000000000000000000000000000000000000001",
+			"FEDCBA9876543210FEDCBA9876543210": "0000000000000000000000000000000000000002",
+		},
+		DataGroupList: map[string]string{
+			"0123456789ABCDEF0123456789ABCDEF": "1",
+			"FEDCBA9876543210FEDCBA9876543210": "42",
+		},
+		ChunkFilesizeListInt: map[string]uint64{
+			"0123456789ABCDEF0123456789ABCDEF": 1000,
+			"FEDCBA9876543210FEDCBA9876543210": 2000,
+		},
+		CustomFields: map[string]string{},
+	}
+}
+
+func TestBinaryManifestRollingHash(t *testing.T) {
+	manifest, err := parseManifest(encodeBinaryManifest(t, testBinaryManifest(), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored little endian in the file, parsed to the value the chunk header holds
+	if hash := manifest.ChunkHashList["0123456789ABCDEF0123456789ABCDEF"]; hash != "0123456789ABCDEF" {
+		t.Fatalf("got hash %s, want 0123456789ABCDEF", hash)
+	}
+
+	chunk := manifest.GetChunk("0123456789ABCDEF0123456789ABCDEF")
+	if url := chunk.GetURL("http://mirror"); url != "http://mirror/Builds/Fortnite/CloudDir/ChunksV4/01/0123456789ABCDEF_0123456789ABCDEF0123456789ABCDEF.chunk" {
+		t.Fatalf("got url %s", url)
+	}
+
+	raw := makeTestChunk(t, []byte("chunk data"), storedAsZlib, chunkHeaderSize, 0x0123456789ABCDEF)
+	if err := chunk.checkRollingHash(NewByteCloser(raw)); err != nil {
+		t.Fatalf("matching chunk rejected: %v", err)
+	}
+
+	other := makeTestChunk(t, []byte("chunk data"), storedAsZlib, chunkHeaderSize, 0x00000000DEADBEEF)
+	if err := chunk.checkRollingHash(NewByteCloser(other)); err == nil {
+		t.Fatal("chunk with another rolling hash accepted")
+	}
+}
+
+// Json manifest of one chunk as Epic serves them, with the rolling hash, data group and size packed little endian
+const testJSONManifest = `{
+	"ManifestFileVersion": "013000000000",
+	"AppNameString": "FortniteReleaseBuilds",
+	"BuildVersionString": "++Fortnite+Release-2.1-CL-3741772-Windows",
+	"FileManifestList": [],
+	"ChunkHashList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "239205171137103069035001"},
+	"ChunkShaList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "%s"},
+	"DataGroupList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "042"},
+	"ChunkFilesizeList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "%s"}
+}`
+
+func TestVerifyChunkFile(t *testing.T) {
+	data := testData(3, 1024)
+	raw := makeTestChunk(t, data, storedAsZlib, chunkHeaderSize, 0x0123456789ABCDEF)
+	sum := sha1.Sum(data)
+
+	manifest, err := parseManifest([]byte(fmt.Sprintf(testJSONManifest, hex.EncodeToString(sum[:]), writePackedUint64(uint64(len(raw))))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guid := "B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4"
+	chunk := manifest.GetChunk(guid)
+	if chunk.Hash != "0123456789ABCDEF" || chunk.DataGroup != 42 || chunk.FileSize != int64(len(raw)) {
+		t.Fatalf("got chunk %+v", chunk)
+	}
+
+	// Every file in its own folder, so names can repeat
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		folder, err := ioutil.TempDir(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(folder, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	corrupt := append([]byte(nil), raw...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"guid name", write(guid, raw), false},
+		{"lowercase guid name", write(strings.ToLower(guid), raw), false},
+		{"url name", write("0123456789ABCDEF_"+guid+".chunk", raw), false},
+		{"corrupt", write("0123456789ABCDEF_"+guid+".chunk", corrupt), true},
+		{"unknown guid", write("00000000000000000000000000000000", raw), true},
+		{"missing", filepath.Join(dir, "0123456789ABCDEF_00000000000000000000000000000001.chunk"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := manifest.VerifyChunkFile(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManifestTotalSizes(t *testing.T) {
+	manifest := testBinaryManifest()
+
+	// A second file reusing the first chunk, which is only downloaded once
+	manifest.FileManifestList = append(manifest.FileManifestList, ManifestFile{
+		FileName: "FortniteGame/Content/Paks/pakchunk1.pak",
+		FileChunkParts: []ManifestFileChunkPart{
+			{GUID: "0123456789ABCDEF0123456789ABCDEF", OffsetInt: 100, SizeInt: 200},
+		},
+	})
+
+	if size := manifest.TotalInstallSize(); size != 350 {
+		t.Fatalf("got install size %d, want 350", size)
+	}
+	if size := manifest.TotalDownloadSize(""); size != 3000 {
+		t.Fatalf("got download size %d, want 3000", size)
+	}
+
+	// Chunks already in the chunk folder aren't downloaded
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "FEDCBA9876543210FEDCBA9876543210"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if size := manifest.TotalDownloadSize(dir); size != 1000 {
+		t.Fatalf("got download size %d with a chunk folder, want 1000", size)
+	}
+
+	// Json manifests pack the part sizes
+	manifest, err := parseManifest([]byte(`{"FileManifestList": [{"Filename": "a", "FileChunkParts": [
+		{"Guid": "B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4", "Offset": "000000000000", "Size": "000004000000"},
+		{"Guid": "B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4", "Offset": "000004000000", "Size": "016000000000"}]}],
+		"ChunkHashList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "000000000000000000000000"},
+		"DataGroupList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "000"},
+		"ChunkFilesizeList": {"B7A1E24E4E2C3F1FA1C1F7A2B0A6A9C4": "100001000000000000000000"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := manifest.TotalInstallSize(); size != 1024+16 {
+		t.Fatalf("got json install size %d, want %d", size, 1024+16)
+	}
+	if size := manifest.TotalDownloadSize(""); size != 100+256 {
+		t.Fatalf("got json download size %d, want %d", size, 100+256)
+	}
+}
+
+func TestMarshalEpicJSONRoundTrip(t *testing.T) {
+	want := testBinaryManifest()
+	want.FileManifestList[0].InstallTags = []string{"chunk0"}
+	binaryManifest, err := parseManifest(encodeBinaryManifest(t, want, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := binaryManifest.MarshalEpicJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonManifest, err := parseManifest(data)
+	if err != nil {
+		t.Fatalf("exported manifest doesn't parse: %v", err)
+	}
+
+	// Chunks download from the same place and check against the same hashes
+	if jsonManifest.FeatureLevel() != binaryManifest.FeatureLevel() {
+		t.Fatalf("got feature level %d, want %d", jsonManifest.FeatureLevel(), binaryManifest.FeatureLevel())
+	}
+	for guid := range want.ChunkHashList {
+		if got, want := jsonManifest.GetChunk(guid), binaryManifest.GetChunk(guid); got != want {
+			t.Fatalf("got chunk %+v, want %+v", got, want)
+		}
+	}
+
+	// Files assemble the same way
+	if len(jsonManifest.FileManifestList) != len(want.FileManifestList) {
+		t.Fatalf("got %d files, want %d", len(jsonManifest.FileManifestList), len(want.FileManifestList))
+	}
+	for i, file := range jsonManifest.FileManifestList {
+		wantFile := want.FileManifestList[i]
+		hash, err := file.Hash()
+		if err != nil || hex.EncodeToString(hash) != wantFile.FileHash {
+			t.Fatalf("got hash %x (%v), want %s", hash, err, wantFile.FileHash)
+		}
+		if file.FileName != wantFile.FileName || strings.Join(file.InstallTags, ",") != strings.Join(wantFile.InstallTags, ",") {
+			t.Fatalf("got file %s with tags %v", file.FileName, file.InstallTags)
+		}
+		for j, part := range file.FileChunkParts {
+			wantPart := wantFile.FileChunkParts[j]
+			if part.GUID != wantPart.GUID || readPackedUint32(part.Offset) != wantPart.OffsetInt || readPackedUint32(part.Size) != wantPart.SizeInt {
+				t.Fatalf("got part %+v, want %+v", part, wantPart)
+			}
+		}
+	}
+
+	// Exporting a json manifest again changes nothing
+	again, err := jsonManifest.MarshalEpicJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Fatal("exporting the exported manifest changed it")
+	}
+}
+
+func TestBinaryManifestPrereqIds(t *testing.T) {
+	want := testBinaryManifest()
+	want.PreReqIds = []string{"b59e5a4a7a1e4c8a9bb1e0c8d5b1c2a3", "0f1e2d3c4b5a69788796a5b4c3d2e1f0"}
+	want.PreReqName = "Fortnite Prerequisites"
+	want.PreReqPath = "FortniteGame/Extras/Redist/en-us/UE4PrereqSetup_x64.exe"
+	want.PreReqArgs = "/quiet /norestart"
+
+	manifest, err := parseManifest(encodeBinaryManifest(t, want, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(manifest.PreReqIds, ",") != strings.Join(want.PreReqIds, ",") {
+		t.Fatalf("got prerequisite ids %v, want %v", manifest.PreReqIds, want.PreReqIds)
+	}
+	if manifest.PreReqName != want.PreReqName || manifest.PreReqPath != want.PreReqPath || manifest.PreReqArgs != want.PreReqArgs {
+		t.Fatalf("got prerequisite %q %q %q", manifest.PreReqName, manifest.PreReqPath, manifest.PreReqArgs)
+	}
+
+	// Everything after the array is still read from the right place
+	if manifest.LaunchExeString != want.LaunchExeString || len(manifest.FileManifestList) != 1 || manifest.FileManifestList[0].FileName != want.FileManifestList[0].FileName {
+		t.Fatalf("got manifest %+v", manifest)
+	}
+	if len(manifest.ChunkHashList) != len(want.ChunkHashList) {
+		t.Fatalf("got %d chunks, want %d", len(manifest.ChunkHashList), len(want.ChunkHashList))
+	}
+
+	// A count larger than the data is rejected
+	data := encodeBinaryManifest(t, testBinaryManifest(), false)
+	body := data[41:]
+	countAt := 14 + 4 + len("Fortnite") + 1 + 4 + len(want.BuildVersionString) + 1 + 4 + len(want.LaunchExeString) + 1 + 4 + len(want.LaunchCommand) + 1
+	binary.LittleEndian.PutUint32(body[countAt:], 1<<30)
+	sum := sha1.Sum(body)
+	copy(data[16:], sum[:])
+	if _, err := parseManifest(data); err == nil || !strings.Contains(err.Error(), "prerequisite") {
+		t.Fatalf("got error %v for a huge prerequisite count", err)
+	}
+}
+
+func TestManifestCustomFields(t *testing.T) {
+	want := map[string]string{"BuildLabel": "Live", "CloudDir": "https://cdn/Builds/Fortnite/CloudDir", "Empty": ""}
+
+	manifest := testBinaryManifest()
+	manifest.CustomFields = want
+	binaryManifest, err := parseManifest(encodeBinaryManifest(t, manifest, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonManifest, err := parseManifest([]byte(`{"FileManifestList": [], "CustomFields": {"BuildLabel": "Live", "CloudDir": "https://cdn/Builds/Fortnite/CloudDir", "Empty": ""}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, got := range map[string]map[string]string{"binary": binaryManifest.CustomFields, "json": jsonManifest.CustomFields} {
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d custom fields %v, want %d", name, len(got), got, len(want))
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Fatalf("%s: got %s = %q, want %q", name, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestManifestFileHash(t *testing.T) {
+	want := sha1.Sum([]byte("file"))
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{"hex", hex.EncodeToString(want[:]), false},
+		{"upper hex", strings.ToUpper(hex.EncodeToString(want[:])), false},
+		{"packed", writePackedData(want[:]), false},
+		{"empty", "", true},
+		{"short hex", hex.EncodeToString(want[:19]), true},
+		{"long hex", hex.EncodeToString(want[:]) + "00", true},
+		{"invalid hex", strings.Repeat("zz", sha1.Size), true},
+		{"short packed", writePackedData(want[:19]), true},
+		{"invalid packed", strings.Repeat("999", sha1.Size), true},
+		{"packed with letters", "abc" + writePackedData(want[1:]), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := ManifestFile{FileHash: tt.hash}
+			got, err := file.Hash()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got hash %x, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want[:]) {
+				t.Fatalf("got hash %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestParseManifestFormatDetection(t *testing.T) {
+	const jsonManifest = `{"BuildVersionString": "++Fortnite+Release-1.0-CL-1-Windows", "FileManifestList": []}`
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"json", []byte(jsonManifest), ""},
+		{"whitespace", []byte(" \t\r\n\n  " + jsonManifest), ""},
+		{"bom", []byte("\xef\xbb\xbf" + jsonManifest), ""},
+		{"bom and whitespace", []byte("\xef\xbb\xbf\r\n" + jsonManifest), ""},
+		{"nil", nil, "empty manifest"},
+		{"empty", []byte{}, "empty manifest"},
+		{"only whitespace", []byte(" \r\n\t"), "unrecognized manifest format"},
+		{"only bom", []byte("\xef\xbb\xbf"), "unrecognized manifest format"},
+		{"short", []byte{0x0C}, "unrecognized manifest format"},
+		{"text", []byte("<html>not found</html>"), "unrecognized manifest format"},
+		{"invalid json", []byte("{\"FileManifestList\": ["), "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := parseManifest(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.BuildVersionString != "++Fortnite+Release-1.0-CL-1-Windows" {
+				t.Fatalf("got build version %q", manifest.BuildVersionString)
+			}
+		})
+	}
+}
+
+// Gzip data like an archived manifest
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.Bytes()
+}
+
+func TestParseGzippedManifest(t *testing.T) {
+	jsonManifest := []byte(`{"BuildVersionString": "++Fortnite+Release-1.0-CL-1-Windows", "FileManifestList": []}`)
+	binaryManifest := encodeBinaryManifest(t, testBinaryManifest(), true)
+	truncated := gzipData(t, jsonManifest)
+	truncated = truncated[:len(truncated)-10]
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"json", gzipData(t, jsonManifest), ""},
+		{"json with bom", gzipData(t, append([]byte("\xef\xbb\xbf"), jsonManifest...)), ""},
+		{"binary", gzipData(t, binaryManifest), ""},
+		{"gzipped twice", gzipData(t, gzipData(t, jsonManifest)), "gzipped manifest is gzipped again"},
+		{"truncated", truncated, "failed to decompress gzipped manifest"},
+		{"only magic", []byte{0x1f, 0x8b}, "failed to decompress gzipped manifest"},
+		{"empty inside", gzipData(t, nil), "empty manifest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := parseManifest(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.BuildVersionString != "++Fortnite+Release-1.0-CL-1-Windows" {
+				t.Fatalf("got build version %q", manifest.BuildVersionString)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Path of a manifest in the -manifest-cache folder
+func manifestCachePath(id string) string {
+	return filepath.Join(manifestCacheDir, url.PathEscape(platform), url.PathEscape(id)+".manifest")
+}
+
+// Fetch a manifest by id, from the -manifest-cache folder if it has a fresh copy.
+// Fetched manifests are stored in the cache for later runs.
+func fetchManifestByID(id string) (*Manifest, error) {
+	if manifestCacheDir == "" {
+		manifest, _, err := fetchManifestMirrors(manifestURLs(id))
+		return manifest, err
+	}
+
+	// Read from cache
+	path := manifestCachePath(id)
+	if fi, err := os.Stat(path); err == nil && !refreshManifests && (manifestCacheTTL == 0 || time.Since(fi.ModTime()) < manifestCacheTTL) {
+		manifest, err := readManifestFile(path)
+		if err == nil {
+			debugf("Manifest %s read from cache.\n", id)
+			return manifest, nil
+		}
+		warnf("Cached manifest %s is unusable, fetching instead: %v\n", id, err)
+	}
+
+	manifest, body, err := fetchManifestMirrors(manifestURLs(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// Store in cache, the manifest is still usable if this fails
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err := writeFileAtomic(path, body); err != nil {
+		warnf("Failed to cache manifest %s: %v\n", id, err)
+	}
+
+	return manifest, nil
+}
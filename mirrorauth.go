@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	mirrorAuthHeader string          // Authorization header value for the configured mirrors
+	mirrorAuthHosts  map[string]bool // hosts of -url and -manifest-url-template
+)
+
+// Parse -mirror-auth, either basic:<user>:<password> or bearer:<token>, into an Authorization header.
+// Errors never include the credentials.
+func parseMirrorAuth(auth string) (string, error) {
+	parts := strings.SplitN(auth, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", errors.New("invalid mirror auth, use basic:<user>:<password> or bearer:<token>")
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "basic":
+		if !strings.Contains(parts[1], ":") {
+			return "", errors.New("invalid basic mirror auth, use basic:<user>:<password>")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(parts[1])), nil
+	case "bearer":
+		return "Bearer " + parts[1], nil
+	}
+
+	return "", errors.New("unknown mirror auth type, use basic or bearer")
+}
+
+// Collect the hosts credentials are sent to, the chunk mirrors and the manifest mirrors
+func mirrorHosts() map[string]bool {
+	hosts := make(map[string]bool)
+	urls := append([]string(nil), downloadURLs...)
+	for _, template := range strings.Split(manifestURLTemplate, ",") {
+		urls = append(urls, strings.NewReplacer("{id}", "", "{platform}", "", "%s", "").Replace(template))
+	}
+
+	for _, rawURL := range urls {
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			hosts[strings.ToLower(u.Host)] = true
+		}
+	}
+
+	return hosts
+}
+
+// Add -mirror-auth credentials to a request going to one of the configured mirrors.
+// Other hosts, such as Epic's account service, never see them.
+func setMirrorAuth(req *http.Request) {
+	if mirrorAuthHeader != "" && mirrorAuthHosts[strings.ToLower(req.URL.Host)] {
+		req.Header.Set("Authorization", mirrorAuthHeader)
+	}
+}
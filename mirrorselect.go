@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Ways of spreading chunk requests over the download urls
+const (
+	mirrorRandom  = "random"  // every mirror equally
+	mirrorLatency = "latency" // more to the mirrors that answer faster
+)
+
+const (
+	mirrorProbeTimeout = 5 * time.Second // longest a startup probe may take, also the time a failed probe counts as
+	mirrorTimeWeight   = 0.2             // weight of a new download time in the running average
+)
+
+// Running average time a chunk request to each mirror takes, for -mirror-strategy latency
+var (
+	mirrorTimes     = make(map[string]time.Duration)
+	mirrorTimesLock sync.Mutex
+)
+
+// Time a request to every mirror at once to start the averages from.
+// Any response counts, the mirror root doesn't have to exist for the round trip to be measured.
+func probeMirrors() {
+	var wg sync.WaitGroup
+	for _, downloadURL := range downloadURLs {
+		wg.Add(1)
+		go func(downloadURL string) {
+			defer wg.Done()
+
+			elapsed := mirrorProbeTimeout
+			start := time.Now()
+			if err := probeMirror(downloadURL); err == nil {
+				elapsed = time.Since(start)
+				debugf("Mirror %s answered in %v.\n", downloadURL, elapsed.Round(time.Millisecond))
+			} else {
+				warnf("Mirror %s didn't answer the latency probe: %v\n", downloadURL, err)
+			}
+
+			mirrorTimesLock.Lock()
+			mirrorTimes[downloadURL] = elapsed
+			mirrorTimesLock.Unlock()
+		}(downloadURL)
+	}
+	wg.Wait()
+}
+
+// Make a HEAD request to a mirror
+func probeMirror(downloadURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	setMirrorAuth(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Fold the time a chunk request took into the mirror's average.
+// A failed request counts double its time, or the probe timeout if it failed fast, so failing mirrors are picked less.
+func recordMirrorTime(downloadURL string, elapsed time.Duration, err error) {
+	if mirrorStrategy != mirrorLatency {
+		return
+	}
+
+	if err != nil {
+		elapsed *= 2
+		if elapsed < mirrorProbeTimeout {
+			elapsed = mirrorProbeTimeout
+		}
+	}
+
+	mirrorTimesLock.Lock()
+	defer mirrorTimesLock.Unlock()
+
+	average, ok := mirrorTimes[downloadURL]
+	if !ok {
+		mirrorTimes[downloadURL] = elapsed
+		return
+	}
+	mirrorTimes[downloadURL] = average + time.Duration(mirrorTimeWeight*float64(elapsed-average))
+}
+
+// Pick a download url with a chance inversely proportional to its average time, avoiding the excluded one if possible.
+// Slower mirrors are still picked now and then, so their averages keep up if they get faster.
+func pickFastMirror(exclude string) string {
+	mirrorTimesLock.Lock()
+	defer mirrorTimesLock.Unlock()
+
+	weights := make([]float64, len(downloadURLs))
+	total := 0.0
+	for i, downloadURL := range downloadURLs {
+		if downloadURL == exclude && len(downloadURLs) > 1 {
+			continue
+		}
+
+		// Mirrors without an average yet count as the fastest so they get tried
+		average, ok := mirrorTimes[downloadURL]
+		if !ok || average < time.Millisecond {
+			average = time.Millisecond
+		}
+		weights[i] = 1 / average.Seconds()
+		total += weights[i]
+	}
+
+	n := rand.Float64() * total
+	for i, weight := range weights {
+		if n < weight {
+			return downloadURLs[i]
+		}
+		n -= weight
+	}
+
+	// Only reached through rounding
+	for i := len(downloadURLs) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return downloadURLs[i]
+		}
+	}
+	return downloadURLs[0]
+}
+
+// Response body recording the time a chunk request took once it's closed
+type timedBody struct {
+	io.ReadCloser
+	downloadURL string
+	start       time.Time
+	err         error
+}
+
+// Read from the body, remembering the first error
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+
+	return n, err
+}
+
+// Close the body and record the time from sending the request
+func (b *timedBody) Close() error {
+	recordMirrorTime(b.downloadURL, time.Since(b.start), b.err)
+	return b.ReadCloser.Close()
+}
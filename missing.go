@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Returned for files that couldn't be assembled because chunks are missing from all mirrors
+var errMissingChunks = errors.New("missing from all mirrors")
+
+// Chunks that failed on every attempt with -continue-on-missing, and the files using them
+var missingChunks = make(map[string][]string)
+var missingChunksLock sync.Mutex
+
+// Attempts per chunk before it's given up on with -continue-on-missing, every mirror gets a few tries
+func chunkAttemptLimit() int {
+	if n := 2 * len(downloadURLs); n > chunkOnlyAttempts {
+		return n
+	}
+	return chunkOnlyAttempts
+}
+
+// Record a chunk as missing for a file
+func recordMissingChunk(guid string, fileName string) {
+	missingChunksLock.Lock()
+	defer missingChunksLock.Unlock()
+
+	for _, name := range missingChunks[guid] {
+		if name == fileName {
+			return
+		}
+	}
+	missingChunks[guid] = append(missingChunks[guid], fileName)
+}
+
+// Check if a chunk already failed on every attempt
+func isMissingChunk(guid string) bool {
+	missingChunksLock.Lock()
+	defer missingChunksLock.Unlock()
+
+	_, ok := missingChunks[guid]
+	return ok
+}
+
+// Log all missing chunks and the files left incomplete by them
+func reportMissingChunks() {
+	missingChunksLock.Lock()
+	defer missingChunksLock.Unlock()
+
+	if len(missingChunks) == 0 {
+		return
+	}
+
+	guids := make([]string, 0, len(missingChunks))
+	files := make(map[string]bool)
+	for guid, names := range missingChunks {
+		guids = append(guids, guid)
+		for _, name := range names {
+			files[name] = true
+		}
+	}
+	sort.Strings(guids)
+
+	errorf("%d chunks are missing from all mirrors, %d files are incomplete:\n", len(guids), len(files))
+	for _, guid := range guids {
+		errorf("  %s, used by %s\n", guid, strings.Join(missingChunks[guid], ", "))
+	}
+}
+
+// Copy of the missing chunks for the run summary
+func collectMissingChunks() map[string][]string {
+	missingChunksLock.Lock()
+	defer missingChunksLock.Unlock()
+
+	if len(missingChunks) == 0 {
+		return nil
+	}
+
+	chunks := make(map[string][]string, len(missingChunks))
+	for guid, names := range missingChunks {
+		chunks[guid] = append([]string(nil), names...)
+	}
+	return chunks
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Files smaller than this are written normally with -mmap, mapping them isn't worth it
+const mmapMinSize = 64 << 20
+
+var errMmapUnsupported = errors.New("memory mapping is not supported on this platform")
+
+// A memory mapped output file, chunk parts are copied into it without write syscalls
+type mappedFile []byte
+
+// WriteAt copies p into the mapping at off.
+// Faults, such as the disk running out of space for a sparse file, are returned as errors instead of crashing.
+func (m mappedFile) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > int64(len(m)) {
+		return 0, fmt.Errorf("write of %d bytes at %d outside of mapped file of %d bytes", len(p), off, len(m))
+	}
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to write to mapped file: %v", r)
+		}
+	}()
+
+	return copy(m[off:], p), nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !windows,!linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package main
+
+import "os"
+
+// Map a file of size bytes for writing, unmap has to be called once done
+func mmapFile(f *os.File, size int64) (m mappedFile, unmap func() error, err error) {
+	return nil, nil, errMmapUnsupported
+}
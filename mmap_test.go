@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Size of the file and the chunk parts written in the -mmap benchmark, a file just large enough to be mapped
+const (
+	benchmarkMmapFileSize = mmapMinSize
+	benchmarkMmapPartSize = 1 << 20
+)
+
+// Create a preallocated output file, mapped if mapped is set
+func createMappedFile(tb testing.TB, path string, size int64, mapped bool) (io.WriterAt, func()) {
+	tb.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		tb.Fatal(err)
+	}
+	if !mapped {
+		return f, func() { f.Close() }
+	}
+
+	m, unmap, err := mmapFile(f, size)
+	if err == errMmapUnsupported {
+		f.Close()
+		tb.Skip(err)
+	}
+	if err != nil {
+		f.Close()
+		tb.Fatal(err)
+	}
+
+	return m, func() {
+		unmap()
+		f.Close()
+	}
+}
+
+func BenchmarkMmapWrites(b *testing.B) {
+	part := testData(1, benchmarkMmapPartSize)
+	offsets := rand.New(rand.NewSource(1)).Perm(benchmarkMmapFileSize / benchmarkMmapPartSize)
+
+	for _, mapped := range []bool{false, true} {
+		name := "WriteAt"
+		if mapped {
+			name = "mmap"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(benchmarkMmapFileSize)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				w, done := createMappedFile(b, filepath.Join(b.TempDir(), "file"), benchmarkMmapFileSize, mapped)
+				b.StartTimer()
+
+				// Chunk parts complete out of order
+				for _, n := range offsets {
+					if _, err := w.WriteAt(part, int64(n)*benchmarkMmapPartSize); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.StopTimer()
+				done()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestMappedFileWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	w, done := createMappedFile(t, path, 4096, true)
+	if _, err := w.WriteAt([]byte("chunk part"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("past the end"), 4090); err == nil {
+		t.Fatal("write past the end of the mapping accepted")
+	}
+	done()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 4096)
+	copy(want[100:], "chunk part")
+	if !bytes.Equal(data, want) {
+		t.Fatal("mapped write didn't reach the file")
+	}
+}
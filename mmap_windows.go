@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"unsafe"
+)
+
+// Map a file of size bytes for writing, unmap has to be called once done
+func mmapFile(f *os.File, size int64) (m mappedFile, unmap func() error, err error) {
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READWRITE, uint32(size>>32), uint32(size), nil)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	defer syscall.CloseHandle(mapping) // the view keeps the mapping alive
+
+	addr, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_WRITE, 0, 0, uintptr(size))
+	if err != nil {
+		return nil, nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+
+	var data []byte
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	header.Data = addr
+	header.Len = int(size)
+	header.Cap = int(size)
+
+	return data, func() error {
+		if err := syscall.FlushViewOfFile(addr, uintptr(size)); err != nil {
+			syscall.UnmapViewOfFile(addr)
+			return os.NewSyscallError("FlushViewOfFile", err)
+		}
+		return os.NewSyscallError("UnmapViewOfFile", syscall.UnmapViewOfFile(addr))
+	}, nil
+}
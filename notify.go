@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// RunSummary defines the end of run summary passed to -webhook, -exec and -stats-file
+type RunSummary struct {
+	Success         bool                   `json:"success"`
+	ExitCode        int                    `json:"exitCode"`
+	BuildVersions   []string               `json:"buildVersions"`
+	Start           time.Time              `json:"start"`
+	End             time.Time              `json:"end"`
+	Files           int                    `json:"files"`
+	DownloadedFiles int                    `json:"downloadedFiles"`
+	FailedFiles     int                    `json:"failedFiles"`
+	CorruptFiles    int                    `json:"corruptFiles"`
+	DownloadedBytes int64                  `json:"downloadedBytes"`
+	NetworkBytes    int64                  `json:"networkBytes"`
+	CacheBytes      int64                  `json:"cacheBytes"` // chunk part bytes served from the in-memory cache
+	LocalBytes      int64                  `json:"localBytes"` // chunk part bytes read from -chunk-dir
+	Duration        float64                `json:"durationSeconds"`
+	Mirrors         map[string]MirrorStats `json:"mirrors"`
+	MissingChunks   map[string][]string    `json:"missingChunks,omitempty"` // files using each chunk missing from all mirrors
+	Corrupt         []CorruptFile          `json:"corrupt"`                 // files failing manifest verification
+}
+
+// Report the end of a run to -webhook and -exec.
+// Failures are only logged, they don't change the outcome of the download.
+func notifyCompletion(summary RunSummary) {
+	if webhookURL == "" && execCommand == "" {
+		return
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		warnf("Failed to encode run summary: %v\n", err)
+		return
+	}
+
+	if webhookURL != "" {
+		if err := postWebhook(webhookURL, data); err != nil {
+			warnf("Failed to call webhook: %v\n", err)
+		}
+	}
+
+	if execCommand != "" {
+		if err := runCommand(execCommand, data, summary.ExitCode); err != nil {
+			warnf("Failed to run %s: %v\n", execCommand, err)
+		}
+	}
+}
+
+// POST the summary as json
+func postWebhook(url string, data []byte) error {
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalid status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Run a command through the shell, with the summary on stdin and the exit code in SPLASH_EXIT_CODE
+func runCommand(command string, data []byte, exitCode int) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("SPLASH_EXIT_CODE=%d", exitCode))
+
+	return cmd.Run()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// Slots for open files, nil when -max-open-files is unlimited
+var openFileSlots chan struct{}
+
+// Set up the -max-open-files limit, 0 picks half of the os limit so connections and other descriptors still fit.
+// Every file being assembled holds a slot while it waits for chunks, so at least one more slot is always left for those.
+func setupOpenFileLimit(limit int) {
+	if limit == 0 {
+		limit = osOpenFileLimit() / 2
+	}
+	if limit <= 0 {
+		return
+	}
+
+	if min := parallelManifests + 1; limit < min {
+		warnf("Raising the open file limit from %d to %d, one more than the files downloaded at once.\n", limit, min)
+		limit = min
+	}
+
+	debugf("Keeping up to %d files open.\n", limit)
+	openFileSlots = make(chan struct{}, limit)
+}
+
+// File holding an open file slot until closed
+type limitedFile struct {
+	*os.File
+	once sync.Once
+}
+
+func (f *limitedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(releaseFileSlot)
+	return err
+}
+
+// Open a file once a slot is free, the slot is released when the file is closed
+func openFile(name string, flag int, perm os.FileMode) (*limitedFile, error) {
+	if openFileSlots != nil {
+		openFileSlots <- struct{}{}
+	}
+
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		releaseFileSlot()
+		return nil, err
+	}
+
+	return &limitedFile{File: f}, nil
+}
+
+func releaseFileSlot() {
+	if openFileSlots != nil {
+		<-openFileSlots
+	}
+}
+
+// Get the os file behind a storage file, for writing to it through a memory mapping
+func osFile(f StorageFile) (*os.File, bool) {
+	switch f := f.(type) {
+	case *os.File:
+		return f, true
+	case *limitedFile:
+		return f.File, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package main
+
+import "syscall"
+
+// Get the soft limit of open file descriptors, 0 if unknown
+func osOpenFileLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0
+	}
+
+	// Effectively unlimited
+	if limit.Cur > 1<<20 {
+		return 0
+	}
+
+	return int(limit.Cur)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Download orders
+const (
+	orderManifest      = "manifest"       // order of the manifests and their file lists
+	orderLargestFirst  = "largest-first"  // biggest files first
+	orderSmallestFirst = "smallest-first" // smallest files first
+)
+
+// Read an order file of file names, one per line, in the order they should be downloaded
+func readOrderFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := filepath.ToSlash(strings.TrimSpace(scanner.Text()))
+		if name == "" || strings.HasPrefix(name, "#") || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names, scanner.Err()
+}
+
+// Warn about names from the order file that aren't in any manifest
+func warnUnknownNames(manifests []*Manifest, names []string) {
+	known := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, file := range manifest.FileManifestList {
+			known[file.FileName] = true
+		}
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			warnf("Ignoring %s from the order file, it isn't in the manifest.\n", name)
+		}
+	}
+}
+
+// Order the files to download, manifestOrder holds all file paths in the order they were loaded.
+// Files in pins are moved to the front, ordered by their pin position.
+func orderFiles(files map[string]ManifestFile, manifestOrder []string, pins map[string]int) []string {
+	names := make([]string, 0, len(files))
+	for _, name := range manifestOrder {
+		if _, ok := files[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	sizes := make(map[string]uint64, len(names))
+	for _, name := range names {
+		file := files[name]
+		sizes[name] = file.Size()
+	}
+
+	switch downloadOrder {
+	case orderLargestFirst:
+		sort.SliceStable(names, func(i, j int) bool {
+			return sizes[names[i]] > sizes[names[j]]
+		})
+	case orderSmallestFirst:
+		sort.SliceStable(names, func(i, j int) bool {
+			return sizes[names[i]] < sizes[names[j]]
+		})
+	}
+
+	if len(pins) > 0 {
+		sort.SliceStable(names, func(i, j int) bool {
+			pi, iPinned := pins[names[i]]
+			pj, jPinned := pins[names[j]]
+			if iPinned != jPinned {
+				return iPinned
+			}
+			return iPinned && pi < pj
+		})
+	}
+
+	return names
+}
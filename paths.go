@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Folder all output paths have to stay within
+func installRoot() string {
+	if installPath == "" {
+		return "."
+	}
+	return installPath
+}
+
+// Check that a file name from a manifest is relative and stays within the folder it's written to
+func validateManifestPath(name string) error {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	switch {
+	case name == "" || clean == ".":
+		return errors.New("empty file name")
+	case path.IsAbs(clean) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || hasDriveLetter(clean):
+		return fmt.Errorf("absolute file name %s", name)
+	case clean == ".." || strings.HasPrefix(clean, "../"):
+		return fmt.Errorf("file name %s leads outside of the install folder", name)
+	}
+
+	return nil
+}
+
+// Whether a name starts with a windows drive letter, rejected everywhere as manifests aren't tied to one platform
+func hasDriveLetter(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+
+	letter := name[0] | 0x20
+	return letter >= 'a' && letter <= 'z'
+}
+
+// Check that an output path stays within the install folder
+func checkOutputPath(outPath string) error {
+	root, err := filepath.Abs(installRoot())
+	if err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(outPath)
+	if err != nil {
+		return err
+	}
+
+	if !isWithin(root, abs) {
+		return fmt.Errorf("%s is outside of %s", outPath, root)
+	}
+
+	return nil
+}
+
+// Check that writing path can't end up outside the install folder through a symlinked folder
+func checkSymlinkEscape(path string) error {
+	root, err := resolvePath(installRoot())
+	if err != nil {
+		return err
+	}
+
+	target, err := resolvePath(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	if !isWithin(root, target) {
+		return fmt.Errorf("%s leads outside of %s through a symlink", path, root)
+	}
+
+	return nil
+}
+
+// Resolve the symlinks of the part of path that exists, giving an absolute path
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Find the deepest existing folder
+	existing, rest := path, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(resolved, rest), nil
+}
+
+// Check if path is root or inside it, both cleaned and absolute
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Mode of an installed file, the launch executable is made executable
+func installFileMode(file ManifestFile) os.FileMode {
+	if file.Executable {
+		return fileMode | 0111
+	}
+	return fileMode
+}
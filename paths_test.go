@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Check a manifest file name the way main does before writing it, returning whether it's accepted
+func acceptManifestPath(layout string, buildVersion string, name string) bool {
+	if validateManifestPath(name) != nil {
+		return false
+	}
+
+	return checkOutputPath(outputPath(layout, installPath, buildVersion, name)) == nil
+}
+
+func TestManifestPathTraversal(t *testing.T) {
+	useTestInstallDir(t)
+
+	manifest, err := parseManifest([]byte(`{
+		"BuildVersionString": "++Fortnite+Release-1.0-CL-1-Windows",
+		"FileManifestList": [
+			{"Filename": "FortniteGame/Content/Paks/pakchunk0.pak"},
+			{"Filename": "FortniteGame/../Engine/Config/Base.ini"},
+			{"Filename": "../evil.txt"},
+			{"Filename": "../../../../etc/cron.d/evil"},
+			{"Filename": "FortniteGame/../../evil.txt"},
+			{"Filename": "..\\..\\evil.bat"},
+			{"Filename": "/etc/passwd"},
+			{"Filename": "C:\\Windows\\evil.dll"},
+			{"Filename": "c:evil.dll"},
+			{"Filename": ".."},
+			{"Filename": ""}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []bool{true, true, false, false, false, false, false, false, false, false, false}
+	for _, layout := range []string{layoutVersioned, layoutFlat} {
+		for i, file := range manifest.FileManifestList {
+			if got := acceptManifestPath(layout, manifest.BuildVersionString, file.FileName); got != want[i] {
+				t.Errorf("%s layout: got accepted %v for %q, want %v", layout, got, file.FileName, want[i])
+			}
+		}
+	}
+
+	// The build version folder can't lead outside either
+	if acceptManifestPath(layoutVersioned, "../../evil", "file.txt") {
+		t.Error("build version leading outside of the install folder accepted")
+	}
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	dir := useTestInstallDir(t)
+	outside := t.TempDir()
+
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks can't be created here: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSymlinkEscape(filepath.Join(dir, "link", "file.txt")); err == nil {
+		t.Error("file in a folder symlinked outside of the install folder accepted")
+	}
+	if err := checkSymlinkEscape(filepath.Join(dir, "link", "new", "file.txt")); err == nil {
+		t.Error("file in a new folder under a symlink accepted")
+	}
+	if err := checkSymlinkEscape(filepath.Join(dir, "real", "new", "file.txt")); err != nil {
+		t.Errorf("file in the install folder rejected: %v", err)
+	}
+}
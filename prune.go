@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Remove files in the output folders that none of the manifests contain, returns the amount of files removed.
+// With dry set the files are only reported.
+func pruneFiles(manifests []*Manifest, dry bool) int {
+	roots, keep := manifestOutputPaths(manifests, installPath)
+
+	pruned := 0
+	for root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			// Leave splash's own folders alone
+			if info.IsDir() {
+				if isSplashFolder(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if keep[path] || isSplashFile(path) {
+				return nil
+			}
+
+			if dry {
+				infof("Would prune %s.\n", path)
+				pruned++
+				return nil
+			}
+
+			if err := os.Remove(path); err != nil {
+				warnf("Failed to prune %s: %v\n", path, err)
+				return nil
+			}
+			debugf("Pruned %s.\n", path)
+			pruned++
+
+			return nil
+		})
+	}
+
+	return pruned
+}
+
+// Check that every manifest's output folder is its own build version folder directly in the install folder.
+// An empty or odd build version would make the install folder, or something above it, the folder to prune.
+func checkPruneRoots(manifests []*Manifest) error {
+	for _, manifest := range manifests {
+		root := outputPath(outputLayout, installPath, manifest.BuildVersionString, "")
+		if outputLayout != layoutVersioned || filepath.Dir(root) != filepath.Clean(installPath) || filepath.Base(root) == ".." {
+			return fmt.Errorf("refusing to prune %s, manifest %q has no build version folder", root, manifest.BuildVersionString)
+		}
+	}
+
+	return nil
+}
+
+// Collect the output folders under dir and every file the manifests contain there, regardless of filters
+func manifestOutputPaths(manifests []*Manifest, dir string) (roots map[string]bool, files map[string]bool) {
+	roots = make(map[string]bool)
+	files = make(map[string]bool)
+	for _, manifest := range manifests {
+		roots[outputPath(outputLayout, dir, manifest.BuildVersionString, "")] = true
+		for _, file := range manifest.FileManifestList {
+			files[outputPath(outputLayout, dir, manifest.BuildVersionString, file.FileName)] = true
+		}
+	}
+
+	return
+}
+
+// Check if a folder is the chunk or temp folder, which aren't part of an install
+func isSplashFolder(path string) bool {
+	return (chunkPath != "" && sameFile(path, chunkPath)) || (tempDir != "" && sameFile(path, tempDir))
+}
+
+// Check if a file is splash's own state or a file still being assembled
+func isSplashFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".splash-") || strings.HasSuffix(path, partialSuffix)
+}
+
+// Check if two paths point to the same file or folder
+func sameFile(a string, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(aInfo, bInfo)
+}
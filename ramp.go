@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// How often downloads waiting during -ramp-up check for a slot
+const rampWaiting = 50 * time.Millisecond
+
+// Chunk downloads running during -ramp-up, which is counted from the first download
+var (
+	rampLock   sync.Mutex
+	rampStart  time.Time
+	rampActive int
+)
+
+// Most chunk downloads that can run at once, what the ramp ends at
+func rampMaxDownloads() int {
+	max := workerCount * parallelManifests
+	if onlyDLChunks {
+		max = chunkWorkerCount
+	}
+	if max < 1 {
+		max = 1
+	}
+
+	return max
+}
+
+// Downloads allowed after elapsed, growing exponentially from 1 to the maximum over -ramp-up
+func rampLimit(elapsed time.Duration) int {
+	if elapsed >= rampUp {
+		return math.MaxInt32
+	}
+
+	return int(math.Ceil(math.Pow(float64(rampMaxDownloads()), float64(elapsed)/float64(rampUp))))
+}
+
+// Wait until a chunk download may start under -ramp-up, call the returned function once it's done
+func acquireRampSlot() func() {
+	if rampUp <= 0 {
+		return func() {}
+	}
+
+	rampLock.Lock()
+	if rampStart.IsZero() {
+		rampStart = time.Now()
+	}
+	for rampActive >= rampLimit(time.Since(rampStart)) {
+		rampLock.Unlock()
+		time.Sleep(rampWaiting)
+		rampLock.Lock()
+	}
+	rampActive++
+	rampLock.Unlock()
+
+	return func() {
+		rampLock.Lock()
+		rampActive--
+		rampLock.Unlock()
+	}
+}
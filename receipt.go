@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Name of the receipt in the install folder
+const receiptFile = ".splash-receipt.json"
+
+// Receipt records the files a run left verified in the install folder
+type Receipt struct {
+	Written       time.Time     `json:"written"`
+	BuildVersions []string      `json:"buildVersions"`
+	Files         []ReceiptFile `json:"files"`
+}
+
+// ReceiptFile defines an installed file, paths are relative to the install folder
+type ReceiptFile struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	Hash         string `json:"hash"` // manifest SHA-1 hash
+	BuildVersion string `json:"buildVersion"`
+	ModTime      int64  `json:"mtime"` // to tell if the file changed since
+}
+
+// Receipt of the previous run, files in it that haven't changed aren't hashed again
+var previousReceipt map[string]ReceiptFile
+
+// Load the receipt of a previous run, a missing receipt is empty
+func readReceipt(dir string) (map[string]ReceiptFile, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, receiptFile))
+	if os.IsNotExist(err) {
+		return make(map[string]ReceiptFile), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]ReceiptFile, len(receipt.Files))
+	for _, file := range receipt.Files {
+		files[filepath.Join(dir, filepath.FromSlash(file.Path))] = file
+	}
+
+	return files, nil
+}
+
+// Check if the previous receipt lists a file with this hash that hasn't changed since
+func receiptMatches(path string, hash string, fi os.FileInfo) bool {
+	file, ok := previousReceipt[path]
+	return ok && file.Hash == hash && file.Size == fi.Size() && file.ModTime == fi.ModTime().UnixNano()
+}
+
+// Pick the files known to be intact at the end of a run.
+// If the integrity check verified every file, all not found corrupt are, otherwise only files checked during the run are known.
+func receiptFiles(files map[string]ManifestFile, checkedFiles map[string]ManifestFile, corruptList []ManifestFile, checkedAll bool) []ManifestFile {
+	corrupt := make(map[string]bool, len(corruptList))
+	for _, file := range corruptList {
+		corrupt[file.FileName] = true
+	}
+
+	intact := make([]ManifestFile, 0, len(files))
+	for k, file := range files {
+		if _, ok := checkedFiles[k]; corrupt[k] || (!checkedAll && !ok) {
+			continue
+		}
+		intact = append(intact, file)
+	}
+
+	return intact
+}
+
+// Write the receipt of the verified files to the install folder.
+// Files of the previous receipt this run didn't touch, such as ones outside the filters, are kept while unchanged.
+// Files that are missing or changed on disk are left out.
+func writeReceipt(dir string, files []ManifestFile, fileSources map[string]string, buildVersions []string) error {
+	receipt := Receipt{
+		Written:       time.Now(),
+		BuildVersions: buildVersions,
+		Files:         make([]ReceiptFile, 0, len(files)),
+	}
+
+	current := make(map[string]bool, len(files))
+	for _, file := range files {
+		current[file.FileName] = true
+
+		fi, err := os.Stat(file.FileName)
+		if err != nil || uint64(fi.Size()) != file.Size() {
+			continue
+		}
+
+		receipt.Files = append(receipt.Files, ReceiptFile{
+			Path:         relativePath(dir, file.FileName),
+			Size:         fi.Size(),
+			Hash:         file.FileHash,
+			BuildVersion: fileSources[file.FileName],
+			ModTime:      fi.ModTime().UnixNano(),
+		})
+	}
+
+	for path, file := range previousReceipt {
+		if current[path] {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil && receiptMatches(path, file.Hash, fi) {
+			receipt.Files = append(receipt.Files, file)
+		}
+	}
+	sort.Slice(receipt.Files, func(i, j int) bool {
+		return receipt.Files[i].Path < receipt.Files[j].Path
+	})
+
+	data, err := json.MarshalIndent(receipt, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, receiptFile), data)
+}
@@ -1,95 +1,452 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"compress/zlib"
 	"crypto/sha1"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"math/rand"
-	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 )
 
-var httpClient = &http.Client{}
-var chunkCache = make(map[string][]byte)
-var chunkParentCount = make(map[string]int)
-var cacheLock sync.Mutex
+var verifyState *VerifyState
+var savedChunks = make(map[string]bool)
+var savedChunksLock sync.Mutex
 
 // Flags
 var (
-	platform           string
-	manifestID         string
-	manifestPath       string
-	installPath        string
-	chunkPath          string
-	onlyDLChunks       bool
-	fileFilter         map[string]bool = make(map[string]bool)
-	downloadURLs       []string
-	skipIntegrityCheck bool
-	workerCount        int
-	killSignal         bool = false
+	platform                  string
+	manifestID                string
+	manifestPath              string
+	manifestURLTemplate       string
+	manifestCacheDir          string
+	manifestCacheTTL          time.Duration
+	refreshManifests          bool
+	refetchOnHashMismatch     bool
+	chunkURLTemplate          string
+	jsonManifestPath          string
+	catalogElementName        string
+	installPath               string
+	outputLayout              string
+	flattenSingle             bool
+	conflictPolicy            string
+	downloadOrder             string
+	downloadOrderPath         string
+	chunkPath                 string
+	chunkDirVersion           int
+	chunkStoreFormat          string
+	onlyDLChunks              bool
+	verifyChunksDir           bool
+	dumpChunkGUID             string
+	resumeChunks              bool
+	saveChunks                bool
+	tempDir                   string
+	fileMode                  os.FileMode
+	dirMode                   os.FileMode
+	mmapWrites                bool
+	cacheCompressed           bool
+	lowMemory                 bool
+	minimalInstall            bool
+	rangeRequests             bool
+	dryRun                    bool
+	diffMode                  bool
+	verifyManifest            bool
+	watchMode                 bool
+	pollInterval              time.Duration
+	jsonOutput                bool
+	comparePath               string
+	fileFilter                map[string]bool = make(map[string]bool)
+	filePrefixFilter          []string
+	fileExcludeFilter         map[string]bool = make(map[string]bool)
+	installTagFilter          map[string]bool = make(map[string]bool)
+	downloadURLs              []string
+	mirrorStrategy            string
+	skipIntegrityCheck        bool
+	preferLocal               bool
+	dedupFiles                bool
+	pruneOld                  bool
+	syncMode                  bool
+	noIntegrityOnExisting     bool
+	verifyCache               bool
+	writeReceiptFile          bool
+	verifyRollingHash         bool
+	corruptPolicy             string
+	checksumPath              string
+	waitForSpace              bool
+	continueOnMissing         bool
+	forceDownload             bool
+	statsPath                 string
+	statusAddr                string
+	webhookURL                string
+	execCommand               string
+	workerCount               int
+	chunkWorkerCount          int
+	prefetchParts             int
+	limitFiles                int
+	parallelManifests         int
+	stallSpeed                int64
+	stallTimeout              time.Duration
+	httpCompression           bool
+	httpHTTP2                 bool
+	httpMaxConnsPerHost       int
+	httpIdleConnsPerHost      int
+	httpBindAddress           string
+	httpIPVersion             int
+	httpProxy                 string
+	httpKeepAlive             time.Duration
+	httpIdleConnTimeout       time.Duration
+	httpTLSHandshakeTimeout   time.Duration
+	httpResponseHeaderTimeout time.Duration
+	requestJitter             time.Duration
+	rampUp                    time.Duration
 )
 
 var version = "v0.0.0"
 
+// Exit codes
+const (
+	exitOK             = 0
+	exitFatal          = 1 // fatal error or interrupted
+	exitDownloadFailed = 3 // some files or chunks failed to download
+	exitCorrupt        = 4 // some files failed verification
+)
+
+// Attempts per chunk in chunk-only mode
+const chunkOnlyAttempts = 5
+
 const defaultDownloadURL = "http://epicgames-download1.akamaized.net"
+const defaultManifestURLTemplate = "https://github.com/polynite/fn-releases/raw/master/manifests/{id}.manifest"
+
+const defaultChunkURLTemplate = "{url}/Builds/Fortnite/CloudDir/{subdir}/{datagroup}/{hash}_{guid}.chunk"
 
-func init() {
+// How often a manifest failing verification is fetched with -refetch-on-hash-mismatch
+const manifestFetchAttempts = 3
+
+// How often a manifest download is resumed before giving up
+const manifestDownloadAttempts = 5
+
+// Register and parse the flags, main runs this first so tests can too once the testing flags are registered
+func parseFlags() {
 	// Seed random
 	rand.Seed(time.Now().Unix())
 
 	// Parse flags
 	flag.StringVar(&platform, "platform", "Windows", "platform to download for")
 	flag.StringVar(&manifestID, "manifest", "", "download specific manifest(s)")
-	flag.StringVar(&manifestPath, "manifest-file", "", "download specific manifest(s) - comma-separated list")
+	flag.StringVar(&manifestURLTemplate, "manifest-url-template", defaultManifestURLTemplate, "comma-separated list of urls to fetch manifests by id from, tried in turn, with {id} and {platform} placeholders")
+	flag.StringVar(&manifestPath, "manifest-file", "", "download specific manifest(s) - comma-separated list of files, folders or zip archives, - for stdin")
+	flag.StringVar(&manifestCacheDir, "manifest-cache", "", "folder to keep manifests fetched by id in, so later runs don't fetch them again")
+	manifestCacheSeconds := flag.Int64("manifest-cache-ttl", 0, "seconds a cached manifest is used for before it's fetched again, 0 for no limit")
+	flag.BoolVar(&refreshManifests, "refresh", false, "fetch manifests again even if they are in the -manifest-cache folder")
+	flag.BoolVar(&refetchOnHashMismatch, "refetch-on-hash-mismatch", false, "fetch the latest manifest again, from other catalog urls if any, when it doesn't match the catalog hash")
+	flag.StringVar(&jsonManifestPath, "to-json-manifest", "", "write the loaded manifest as an Epic json manifest to this path and exit")
+	flag.StringVar(&catalogElementName, "catalog-element", "", "catalog element to download, by app name or index")
 	flag.StringVar(&installPath, "install-dir", "", "folder to write downloaded files to")
+	flag.StringVar(&outputLayout, "output-layout", layoutVersioned, "install directory structure: versioned, flat or none")
+	flag.BoolVar(&flattenSingle, "flatten-single-manifest", false, "write directly into -install-dir without a build version folder when only one manifest is loaded")
+	flag.StringVar(&conflictPolicy, "conflict", conflictLast, "how to handle differing files with the same path across manifests: last, first or error")
+	flag.StringVar(&downloadOrder, "order", orderManifest, "order to download files in: manifest, largest-first or smallest-first")
+	flag.StringVar(&downloadOrderPath, "order-file", "", "file of file names, one per line, to download first in that order before all others")
+	flag.StringVar(&chunkURLTemplate, "chunk-url-template", defaultChunkURLTemplate, "chunk path on the download urls, with {url}, {subdir}, {datagroup}, {hash} and {guid} placeholders")
+	flag.IntVar(&chunkDirVersion, "chunk-dir-version", 0, "cloud chunk folder version (1-4 for Chunks to ChunksV4), detected from the manifest by default")
 	flag.StringVar(&chunkPath, "chunk-dir", "", "folder to read predownloaded chunks from")
+	fileModeName := flag.String("file-mode", "0644", "permissions of downloaded files in octal, limited by the umask, the launch executable also gets execute permission")
+	dirModeName := flag.String("dir-mode", "0755", "permissions of created folders in octal, limited by the umask")
+	flag.StringVar(&tempDir, "tempdir", "", "folder to assemble files in before moving them into place, defaults to next to each file")
 	flag.BoolVar(&onlyDLChunks, "chunks-only", false, "only download chunks")
+	flag.StringVar(&dumpChunkGUID, "dump-chunk", "", "print the header of the chunk with this guid, check it and write its data to <guid>.bin, then exit")
+	flag.BoolVar(&verifyChunksDir, "verify-chunks-dir", false, "verify the chunks in the chunk folder against the manifest and exit")
+	flag.BoolVar(&resumeChunks, "resume-chunks", false, "remember verified chunks in the chunk folder so -chunks-only can resume quickly")
+	flag.BoolVar(&verifyRollingHash, "verify-rolling-hash", false, "also check the rolling hash in chunk headers against the manifest, catches wrong chunks being served")
+	flag.BoolVar(&rangeRequests, "range-requests", false, "only download the needed part of uncompressed chunks used once")
+	flag.BoolVar(&saveChunks, "save-chunks", false, "save downloaded chunks to the chunk folder for later runs")
+	flag.BoolVar(&mmapWrites, "mmap", false, "write large files through a memory mapping instead of a write per chunk part")
+	flag.StringVar(&chunkStoreFormat, "chunk-store-format", chunkStoreRaw, "format chunks are written to the chunk folder in: raw or decompressed, both are read")
+	flag.BoolVar(&lowMemory, "low-memory", false, "keep memory use small and bounded, no chunks are cached and every chunk is decompressed as it's read, shared chunks are downloaded again for every use")
+	flag.BoolVar(&cacheCompressed, "cache-compressed", false, "keep cached chunks compressed and decompress them on every use, saves memory at the cost of cpu")
+	flag.BoolVar(&watchMode, "watch", false, "keep running, polling the catalog and downloading whenever the build version changes")
+	pollSeconds := flag.Int64("poll-interval", 600, "seconds between catalog polls with -watch")
+	flag.BoolVar(&verifyManifest, "verify-manifest", false, "check that the manifests given as arguments or with -manifest-file parse and are intact, then exit")
+	flag.BoolVar(&diffMode, "diff", false, "show changes between two manifests given as arguments")
+	flag.BoolVar(&jsonOutput, "json", false, "print -diff and -compare output as json")
+	flag.StringVar(&comparePath, "compare", "", "compare a folder against the manifest without downloading, listing missing, extra and differing files")
+	flag.BoolVar(&dryRun, "dry-run", false, "report what would be downloaded without downloading")
 	dlFilter := flag.String("files", "", "comma-separated list of files to download")
+	flag.BoolVar(&minimalInstall, "minimal", false, "only download core files, the ones without install tags, for a minimal playable install")
+	addTags := flag.String("add-tags", "", "comma-separated list of install tags to download the files of, core files are left alone so tags can be added to an existing install")
+	dlExcludeFilter := flag.String("exclude-files", "", "comma-separated list of files not to download, takes precedence over -files")
+	dlPrefixFilter := flag.String("files-prefix", "", "comma-separated list of path prefixes or glob patterns of files to download")
 	dlUrls := flag.String("url", defaultDownloadURL, "download url")
+	flag.StringVar(&mirrorStrategy, "mirror-strategy", mirrorRandom, "how to spread chunk requests over the download urls: random, or latency to favor the mirrors answering fastest")
+	mirrorAuth := flag.String("mirror-auth", "", "credentials for private chunk and manifest mirrors, basic:<user>:<password> or bearer:<token>")
+	logLevelName := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	quiet := flag.Bool("quiet", false, "only log errors")
 	httpTimeout := flag.Int64("http-timeout", 60, "http timeout in seconds")
+	keepAliveSeconds := flag.Int64("http-keepalive", 30, "seconds between tcp keep-alive probes, negative to disable")
+	idleConnSeconds := flag.Int64("idle-conn-timeout", 90, "seconds an idle connection is kept open for reuse, 0 for no limit")
+	tlsHandshakeSeconds := flag.Int64("tls-handshake-timeout", 10, "seconds to wait for a tls handshake, 0 for no limit")
+	responseHeaderSeconds := flag.Int64("response-header-timeout", 0, "seconds to wait for response headers after sending a request, 0 for no limit")
+	flag.BoolVar(&httpCompression, "http-compression", true, "request gzip compressed manifests and catalogs")
+	flag.BoolVar(&httpHTTP2, "http2", true, "use http/2 when the server supports it")
+	flag.IntVar(&httpMaxConnsPerHost, "http-max-conns", 0, "maximum connections per host, 0 for unlimited")
+	flag.StringVar(&httpBindAddress, "bind-address", "", "local ip or network interface to download from")
+	flag.StringVar(&httpProxy, "proxy", "", "proxy to connect through, e.g. socks5://127.0.0.1:9050 for tor, defaults to the HTTP_PROXY and HTTPS_PROXY environment variables")
+	jitterMilliseconds := flag.Int64("request-jitter", 0, "wait a random amount of milliseconds up to this before every chunk request")
+	rampSeconds := flag.Int64("ramp-up", 0, "seconds to ramp up to all workers downloading at once, starting with one, 0 starts them all right away")
+	flag.IntVar(&httpIPVersion, "ip-version", 0, "only connect over ip version 4 or 6, 0 for both")
+	flag.IntVar(&httpIdleConnsPerHost, "http-idle-conns", 0, "idle connections kept open per host, defaults to the amount of workers")
+	flag.Int64Var(&stallSpeed, "stall-speed", 1024, "minimum chunk download speed in bytes per second before a download counts as stalled")
+	stallSeconds := flag.Int64("stall-timeout", 15, "seconds a chunk download may stay below -stall-speed before being retried, 0 to disable")
 	flag.BoolVar(&skipIntegrityCheck, "skipcheck", false, "skip file integrity check")
+	flag.BoolVar(&syncMode, "sync", false, "hash all existing files first, report which differ from the manifest and only download those")
+	flag.BoolVar(&pruneOld, "prune", false, "delete files in the output folder that aren't in the manifest once the download succeeded")
+	flag.BoolVar(&dedupFiles, "dedup", false, "hardlink files identical to one already written instead of assembling them again")
+	flag.BoolVar(&preferLocal, "prefer-local", false, "treat existing files of the right size as complete without hashing them, only the integrity check catches corruption")
+	flag.BoolVar(&noIntegrityOnExisting, "no-integrity-on-existing", false, "don't verify files found on disk before downloading again in the integrity check, use with -prefer-local")
+	flag.BoolVar(&writeReceiptFile, "write-receipt", false, "write the installed files with their sizes, hashes and builds to "+receiptFile+" in the install folder, unchanged files in it aren't hashed again")
+	flag.BoolVar(&verifyCache, "verify-cache", false, "remember verified files in the install folder so unchanged files aren't hashed again")
+	flag.StringVar(&corruptPolicy, "on-corrupt", corruptReport, "what to do with files failing verification: report, delete, redownload or quarantine")
+	flag.StringVar(&checksumPath, "checksums", "", "file of \"filename sha256\" pairs to verify after download")
 	flag.IntVar(&workerCount, "workers", 10, "amount of workers")
+	maxOpenFiles := flag.Int("max-open-files", 0, "maximum amount of files open at once, 0 uses half of the os limit, -1 is unlimited")
+	flag.IntVar(&prefetchParts, "prefetch", 0, "maximum amount of chunk parts per file fetched but not written yet, caps memory use when parts finish out of order, 0 is unlimited")
+	flag.IntVar(&chunkWorkerCount, "chunk-workers", 0, "amount of workers for -chunks-only, defaults to -workers")
+	flag.IntVar(&parallelManifests, "parallel-manifests", 1, "download the files of this many manifests at once, each manifest uses up to -workers workers")
+	flag.IntVar(&limitFiles, "limit-files", 0, "only process the first n files, sorted by path")
+	flag.BoolVar(&continueOnMissing, "continue-on-missing", false, "give up on chunks failing on every mirror, leave the files using them incomplete and continue with the others")
+	flag.BoolVar(&waitForSpace, "wait-for-space", false, "wait and retry when the disk is full instead of stopping")
+	flag.BoolVar(&forceDownload, "force", false, "download even if there doesn't seem to be enough free disk space")
+	flag.StringVar(&statusAddr, "status-addr", "", "address to serve progress as json on while running, e.g. :8080")
+	flag.StringVar(&statsPath, "stats-file", "", "write a json report of the run to this path once done")
+	flag.StringVar(&webhookURL, "webhook", "", "url to POST a json summary to once done")
+	flag.StringVar(&execCommand, "exec", "", "command to run once done, gets the json summary on stdin and the exit code in SPLASH_EXIT_CODE")
+	configPath := flag.String("config", "", "json file of flag values, flags given on the command line take precedence")
 	flag.Parse()
 
-	if manifestPath == "" {
+	// Apply config file
+	if *configPath != "" {
+		if err := applyConfig(*configPath); err != nil {
+			log.Fatalf("Failed to load config %s: %v", *configPath, err)
+		}
+	}
+
+	// Set log level
+	if *quiet {
+		logLevel = levelError
+	} else if level, err := parseLogLevel(*logLevelName); err != nil {
+		log.Fatal(err)
+	} else {
+		logLevel = level
+	}
+
+	if manifestPath == "" && !diffMode && !verifyManifest {
 		manifestPath = flag.Arg(0)
 	}
 
+	if chunkDirVersion < 0 || chunkDirVersion > len(chunkSubdirs) {
+		log.Fatalf("Unknown chunk folder version %d", chunkDirVersion)
+	}
+
+	if chunkWorkerCount <= 0 {
+		chunkWorkerCount = workerCount
+	}
+
+	if prefetchParts < 0 {
+		log.Fatalf("Invalid prefetch window %d", prefetchParts)
+	}
+
+	if *maxOpenFiles < -1 {
+		log.Fatalf("Invalid open file limit %d", *maxOpenFiles)
+	}
+
+	if httpIPVersion != 0 && httpIPVersion != 4 && httpIPVersion != 6 {
+		log.Fatalf("Unknown ip version %d", httpIPVersion)
+	}
+
+	if pruneOld && outputLayout != layoutVersioned {
+		log.Fatal("-prune only works in build version folders, use the versioned output layout")
+	}
+
+	if verifyChunksDir && chunkPath == "" {
+		log.Fatal("-verify-chunks-dir requires -chunk-dir")
+	}
+
+	if saveChunks && chunkPath == "" {
+		log.Fatal("-save-chunks requires -chunk-dir")
+	}
+
+	if mode, err := strconv.ParseUint(*fileModeName, 8, 32); err != nil || mode > 0777 {
+		log.Fatalf("Invalid file mode %s", *fileModeName)
+	} else {
+		fileMode = os.FileMode(mode)
+	}
+
+	if mode, err := strconv.ParseUint(*dirModeName, 8, 32); err != nil || mode > 0777 {
+		log.Fatalf("Invalid folder mode %s", *dirModeName)
+	} else {
+		dirMode = os.FileMode(mode)
+	}
+
+	if chunkStoreFormat != chunkStoreRaw && chunkStoreFormat != chunkStoreDecompressed {
+		log.Fatalf("Unknown chunk store format %s", chunkStoreFormat)
+	}
+
+	if outputLayout != layoutVersioned && outputLayout != layoutFlat && outputLayout != layoutNone {
+		log.Fatalf("Unknown output layout %s", outputLayout)
+	}
+
+	if corruptPolicy != corruptReport && corruptPolicy != corruptDelete && corruptPolicy != corruptRedownload && corruptPolicy != corruptQuarantine {
+		log.Fatalf("Unknown corrupt file policy %s", corruptPolicy)
+	}
+
+	if conflictPolicy != conflictLast && conflictPolicy != conflictFirst && conflictPolicy != conflictError {
+		log.Fatalf("Unknown conflict policy %s", conflictPolicy)
+	}
+
+	if downloadOrder != orderManifest && downloadOrder != orderLargestFirst && downloadOrder != orderSmallestFirst {
+		log.Fatalf("Unknown download order %s", downloadOrder)
+	}
+
 	for _, file := range strings.Split(*dlFilter, ",") {
 		if file != "" {
 			fileFilter[file] = true
 		}
 	}
 
+	for _, file := range strings.Split(*dlExcludeFilter, ",") {
+		if file != "" {
+			fileExcludeFilter[file] = true
+		}
+	}
+
+	for _, tag := range strings.Split(*addTags, ",") {
+		if tag != "" {
+			installTagFilter[strings.ToLower(tag)] = true
+		}
+	}
+
+	for _, prefix := range strings.Split(*dlPrefixFilter, ",") {
+		if prefix != "" {
+			filePrefixFilter = append(filePrefixFilter, filepath.ToSlash(prefix))
+		}
+	}
+
 	downloadURLs = strings.Split(*dlUrls, ",")
+	if mirrorStrategy != mirrorRandom && mirrorStrategy != mirrorLatency {
+		log.Fatalf("Unknown mirror strategy %s", mirrorStrategy)
+	}
+	if *mirrorAuth != "" {
+		header, err := parseMirrorAuth(*mirrorAuth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mirrorAuthHeader = header
+		mirrorAuthHosts = mirrorHosts()
+	}
 	httpClient.Timeout = time.Duration(*httpTimeout) * time.Second
+	httpKeepAlive = time.Duration(*keepAliveSeconds) * time.Second
+	httpIdleConnTimeout = time.Duration(*idleConnSeconds) * time.Second
+	httpTLSHandshakeTimeout = time.Duration(*tlsHandshakeSeconds) * time.Second
+	httpResponseHeaderTimeout = time.Duration(*responseHeaderSeconds) * time.Second
+	transport, err := newTransport()
+	if err != nil {
+		log.Fatal(err)
+	}
+	httpClient.Transport = transport
+	stallTimeout = time.Duration(*stallSeconds) * time.Second
+	requestJitter = time.Duration(*jitterMilliseconds) * time.Millisecond
+	manifestCacheTTL = time.Duration(*manifestCacheSeconds) * time.Second
+	pollInterval = time.Duration(*pollSeconds) * time.Second
+	rampUp = time.Duration(*rampSeconds) * time.Second
+
+	// Downloads started by -watch run once
+	if os.Getenv(watchChildEnv) != "" {
+		watchMode = false
+	}
+	if watchMode && (manifestID != "" || manifestPath != "") {
+		log.Fatal("-watch only works with the catalog, not -manifest-id or -manifest-file")
+	}
+	if watchMode && pollInterval <= 0 {
+		log.Fatalf("Invalid poll interval %d", *pollSeconds)
+	}
+
+	setupOpenFileLimit(*maxOpenFiles)
+
+	// Collect garbage sooner so the heap stays close to what's live
+	if lowMemory {
+		debug.SetGCPercent(20)
+	}
 }
 
 func main() {
-	fmt.Printf("splash %s\n", version)
+	parseFlags()
+
+	// Handle manifest diff
+	if diffMode {
+		if flag.NArg() != 2 {
+			log.Fatal("Usage: splash -diff <manifest> <manifest>")
+		}
+
+		a, err := loadManifest(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to load manifest %s: %v", flag.Arg(0), err)
+		}
+		b, err := loadManifest(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("Failed to load manifest %s: %v", flag.Arg(1), err)
+		}
+
+		if err := printDiff(diffManifests(a, b), jsonOutput); err != nil {
+			log.Fatalf("Failed to print diff: %v", err)
+		}
+		return
+	}
+
+	// Handle manifest verification
+	if verifyManifest {
+		sources := flag.Args()
+		if manifestPath != "" {
+			sources = strings.Split(manifestPath, ",")
+		}
+		if len(sources) == 0 {
+			log.Fatal("Usage: splash -verify-manifest <manifest>...")
+		}
+
+		os.Exit(verifyManifests(sources))
+	}
+
+	if logLevel <= levelInfo && !jsonOutput {
+		fmt.Printf("splash %s\n", version)
+	}
+
+	// Handle catalog watching
+	if watchMode {
+		os.Exit(watchCatalog())
+	}
 
 	var catalog *Catalog
+	var catalogElement int
 	manifests := make([]*Manifest, 0)
 
 	// Load catalog
 	if manifestID == "" && manifestPath == "" {
 		// Fetch latest catalog
-		log.Println("Fetching latest catalog...")
+		infof("Fetching latest catalog...")
 
 		// Fetch from MCP
-		catalogBytes, err := fetchCatalog(platform, "fn", "4fe75bbc5a674f4f9b356b5c90567da5", "Fortnite", "Live")
+		catalogBytes, err := fetchLiveCatalog()
 		if err != nil {
 			log.Fatalf("Failed to fetch catalog: %v", err)
 		}
@@ -100,20 +457,26 @@ func main() {
 			log.Fatalf("Failed to parse catalog: %v", err)
 		}
 
-		// Sanity check catalog
-		if len(catalog.Elements) != 1 || len(catalog.Elements[0].Manifests) < 1 {
-			log.Fatal("Unsupported catalog")
+		// Select catalog element, parsing made sure there is one with manifests
+		if catalogElementName != "" {
+			catalogElement, err = catalog.FindElement(catalogElementName)
+			if err != nil {
+				log.Fatalf("Failed to select catalog element: %v", err)
+			}
+		} else if len(catalog.Elements) > 1 {
+			catalogElement = promptCatalogElement(catalog)
 		}
 
-		log.Printf("Catalog %s (%s) %s loaded.\n", catalog.Elements[0].AppName, catalog.Elements[0].LabelName, catalog.Elements[0].BuildVersion)
+		element := catalog.Elements[catalogElement]
+		infof("Catalog %s (%s) %s loaded.\n", element.AppName, element.LabelName, element.BuildVersion)
 	}
 
 	// Load manifest
 	if manifestID != "" { // fetch specific manifest(s)
 		for _, id := range strings.Split(manifestID, ",") {
-			log.Printf("Fetching manifest %s...", id)
+			infof("Fetching manifest %s...", id)
 
-			manifest, _, err := fetchManifest(fmt.Sprintf("https://github.com/polynite/fn-releases/raw/master/manifests/%s.manifest", id))
+			manifest, err := fetchManifestByID(id)
 			if err != nil {
 				log.Fatalf("Failed to fetch manifest: %v", err)
 			}
@@ -121,6 +484,30 @@ func main() {
 		}
 	} else if manifestPath != "" { // read manifest(s) from disk
 		for _, manifestPath := range strings.Split(manifestPath, ",") {
+			// Read from stdin
+			if manifestPath == "-" {
+				manifest, err := readManifest(os.Stdin)
+				if err != nil {
+					log.Fatalf("Failed to read manifest from stdin: %v", err)
+				}
+
+				infof("Manifest %s %s loaded (%s installed).\n", manifest.AppNameString, manifest.BuildVersionString, formatBytes(int64(manifest.TotalInstallSize())))
+				manifests = append(manifests, manifest)
+				continue
+			}
+
+			// Read every manifest in a zip archive
+			if strings.EqualFold(filepath.Ext(manifestPath), ".zip") {
+				archiveManifests, err := readManifestArchive(manifestPath)
+				if err != nil {
+					log.Fatalf("Failed to read manifests from archive: %v", err)
+				}
+				manifests = append(manifests, archiveManifests...)
+
+				infof("Loaded %d manifests from %s.\n", len(archiveManifests), manifestPath)
+				continue
+			}
+
 			// Check if folder
 			if fi, err := os.Stat(manifestPath); err == nil && fi.IsDir() {
 				loaded := 0
@@ -148,7 +535,7 @@ func main() {
 					log.Fatalf("Failed to read manifests from folder: %v", err)
 				}
 
-				log.Printf("Loaded %d manifests from %s.\n", loaded, manifestPath)
+				infof("Loaded %d manifests from %s.\n", loaded, manifestPath)
 				continue
 			}
 
@@ -157,266 +544,700 @@ func main() {
 				log.Fatalf("Failed to read manifest %s: %v", manifestPath, err)
 			}
 
-			log.Printf("Manifest %s %s loaded.\n", manifest.AppNameString, manifest.BuildVersionString)
+			infof("Manifest %s %s loaded (%s installed).\n", manifest.AppNameString, manifest.BuildVersionString, formatBytes(int64(manifest.TotalInstallSize())))
 
 			manifests = append(manifests, manifest)
 		}
 	} else { // otherwise, fetch from catalog
-		log.Println("Fetching latest manifest...")
+		infof("Fetching latest manifest...")
 
-		manifest, _, err := fetchManifest(catalog.GetManifestURL())
+		manifest, err := fetchCatalogManifest(&catalog.Elements[catalogElement])
 		if err != nil {
 			log.Fatalf("Failed to fetch manifest: %v", err)
 		}
 		manifests = append(manifests, manifest)
 	}
 
+	// Load checksum file
+	var checksums map[string]string
+	if checksumPath != "" {
+		var err error
+		checksums, err = readChecksumFile(checksumPath)
+		if err != nil {
+			log.Fatalf("Failed to read checksum file: %v", err)
+		}
+	}
+
+	// Load order file
+	var pinnedNames []string
+	if downloadOrderPath != "" {
+		var err error
+		pinnedNames, err = readOrderFile(downloadOrderPath)
+		if err != nil {
+			log.Fatalf("Failed to read order file: %v", err)
+		}
+		warnUnknownNames(manifests, pinnedNames)
+	}
+	warnUnknownTags(manifests)
+	pinnedPositions := make(map[string]int, len(pinnedNames))
+	for i, name := range pinnedNames {
+		pinnedPositions[name] = i
+	}
+
+	// Catch inconsistent manifests before building chunks from them
+	for _, manifest := range manifests {
+		if err := checkManifestChunks(manifest); err != nil {
+			log.Fatalf("Manifest %s is invalid: %v", manifest.BuildVersionString, err)
+		}
+	}
+
+	// Write a lone manifest straight into the install folder, several keep their folders so they can't collide
+	if flattenSingle && len(manifests) == 1 && outputLayout == layoutVersioned && installPath != "" {
+		if pruneOld {
+			log.Fatal("-prune only works in build version folders, not with -flatten-single-manifest")
+		}
+		outputLayout = layoutFlat
+	}
+
+	// Never prune outside a build version folder
+	if pruneOld {
+		if err := checkPruneRoots(manifests); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Handle chunk dump
+	if dumpChunkGUID != "" {
+		os.Exit(dumpChunk(manifests, dumpChunkGUID))
+	}
+
+	// Handle install comparison
+	if comparePath != "" {
+		comparison := compareInstall(manifests, comparePath)
+		if err := printComparison(comparison, jsonOutput); err != nil {
+			log.Fatalf("Failed to print comparison: %v", err)
+		}
+		if comparison.Differences() > 0 {
+			os.Exit(exitCorrupt)
+		}
+		return
+	}
+
+	// Handle json manifest export
+	if jsonManifestPath != "" {
+		if len(manifests) != 1 {
+			log.Fatalf("Can only convert a single manifest to json, got %d", len(manifests))
+		}
+
+		data, err := manifests[0].MarshalEpicJSON()
+		if err != nil {
+			log.Fatalf("Failed to convert manifest: %v", err)
+		}
+
+		if err := writeFileAtomic(jsonManifestPath, data); err != nil {
+			log.Fatalf("Failed to write manifest: %v", err)
+		}
+
+		infof("Manifest written to %s.\n", jsonManifestPath)
+		return
+	}
+
 	manifestFiles := make(map[string]ManifestFile)
+	fileChecksums := make(map[string]string)
 	manifestChunks := make(map[string]Chunk)
 	checkedFiles := make(map[string]ManifestFile)
+	fileSources := make(map[string]string)
+	var fileOrder []string
+	rejectedFiles := 0
+	filePins := make(map[string]int)
 
 	// Parse manifests
 	for _, manifest := range manifests {
 		for _, file := range manifest.FileManifestList {
 			// Check filter
-			if _, ok := fileFilter[file.FileName]; !ok && len(fileFilter) > 0 {
+			if !matchesFilter(file.FileName) || !matchesInstallTags(file) {
+				continue
+			}
+
+			// Reject file names escaping the install folder
+			if err := validateManifestPath(file.FileName); err != nil {
+				errorf("Skipping file of %s: %v\n", manifest.BuildVersionString, err)
+				rejectedFiles++
 				continue
 			}
 
 			// Set full file path
-			file.FileName = filepath.Join(installPath, strings.TrimSuffix(strings.TrimPrefix(manifest.BuildVersionString, "++Fortnite+Release-"), "-"+platform), file.FileName)
+			manifestName := file.FileName
+			file.Executable = manifest.LaunchExeString != "" && filepath.ToSlash(manifestName) == filepath.ToSlash(manifest.LaunchExeString)
+			file.FileName = outputPath(outputLayout, installPath, manifest.BuildVersionString, file.FileName)
+			if err := checkOutputPath(file.FileName); err != nil {
+				errorf("Skipping file of %s: %v\n", manifest.BuildVersionString, err)
+				rejectedFiles++
+				continue
+			}
+
+			// Check for conflicts with previously loaded manifests
+			if existing, ok := manifestFiles[file.FileName]; ok {
+				if !resolveConflict(existing, file, fileSources[file.FileName], manifest.BuildVersionString) {
+					continue
+				}
+
+				// Release chunks of the replaced file
+				for _, c := range existing.FileChunkParts {
+					chunkCache.AddParents(c.GUID, -1)
+				}
+			}
 
 			// Add file
+			if _, ok := manifestFiles[file.FileName]; !ok {
+				fileOrder = append(fileOrder, file.FileName)
+			}
 			manifestFiles[file.FileName] = file
+			fileSources[file.FileName] = manifest.BuildVersionString
+
+			// Pin position from the order file
+			if pos, ok := pinnedPositions[manifestName]; ok {
+				filePins[file.FileName] = pos
+			} else {
+				delete(filePins, file.FileName)
+			}
+
+			// Set expected checksum
+			if checksum, ok := checksums[manifestName]; ok {
+				fileChecksums[file.FileName] = checksum
+			}
 
 			// Add all chunks
 			for _, c := range file.FileChunkParts {
-				chunkParentCount[c.GUID]++
+				chunkCache.AddParents(c.GUID, 1)
 
 				if _, ok := manifestChunks[c.GUID]; !ok { // don't add duplicates
-					if c.SizeInt != 0 {
-						manifestChunks[c.GUID] = NewChunkInt(c.GUID, manifest.ChunkHashList[c.GUID], manifest.ChunkShaList[c.GUID], manifest.DataGroupList[c.GUID], manifest.ChunkFilesizeListInt[c.GUID])
-					} else {
-						manifestChunks[c.GUID] = NewChunk(c.GUID, manifest.ChunkHashList[c.GUID], manifest.ChunkShaList[c.GUID], manifest.DataGroupList[c.GUID], manifest.ChunkFilesizeList[c.GUID])
-					}
+					manifestChunks[c.GUID] = manifest.GetChunk(c.GUID)
 				}
 			}
 		}
 	}
 
+	// Limit file count
+	if limitFiles > 0 && len(manifestFiles) > limitFiles {
+		limitManifestFiles(manifestFiles, manifestChunks, fileChecksums, limitFiles)
+		infof("Limited download to %d files.\n", limitFiles)
+	}
+
+	// Plan sync
+	var plan SyncPlan
+	if syncMode {
+		infof("Comparing %d files against the manifest...\n", len(manifestFiles))
+		plan = planSync(manifestFiles)
+		printSyncPlan(plan)
+
+		if dryRun {
+			os.Exit(exitOK)
+		}
+	}
+
+	// Handle dry run
+	if dryRun {
+		reportDryRun(manifestFiles, manifestChunks)
+		if pruneOld {
+			infof("Files to prune: %d\n", pruneFiles(manifests, true))
+		}
+		os.Exit(exitOK)
+	}
+
 	// Setup interrupt handler
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		log.Println("Shutting down...")
-		killSignal = true
-	}()
+	handleInterrupts()
+
+	// Serve progress
+	if statusAddr != "" {
+		serveStatus(statusAddr)
+	}
+
+	// Load verification state
+	if verifyCache {
+		var err error
+		verifyState, err = openVerifyState(installPath)
+		if err != nil {
+			log.Fatalf("Failed to open verification state: %v", err)
+		}
+		defer verifyState.Close()
+	}
+
+	// Load the previous receipt
+	if writeReceiptFile {
+		var err error
+		previousReceipt, err = readReceipt(installPath)
+		if err != nil {
+			warnf("Ignoring unreadable receipt: %v\n", err)
+		}
+	}
+
+	// Handle chunk folder verification
+	if verifyChunksDir {
+		os.Exit(verifyChunkDir(manifestChunks))
+	}
+
+	// Measure mirror latency
+	if mirrorStrategy == mirrorLatency {
+		probeMirrors()
+	}
 
 	// Handle chunk-only download
 	if onlyDLChunks {
-		log.Printf("Downloading %d chunks...\n", len(manifestChunks))
-
-		// Build job queue
-		jobs := make(chan Chunk, len(manifestChunks))
-		for _, chunk := range manifestChunks {
-			jobs <- chunk
-		}
-		close(jobs)
-
-		// Workers
-		var wg sync.WaitGroup
-		for i := 0; i < workerCount; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for j := range jobs {
-					if killSignal {
-						return
-					}
+		os.Exit(downloadChunks(manifestChunks))
+	}
 
-					filePath := filepath.Join(chunkPath, j.GUID)
+	infof("Downloading %d files in %d chunks from %d manifests.\n", len(manifestFiles), len(manifestChunks), len(manifests))
 
-					// Check if present on disk
-					if fi, err := os.Stat(filePath); err == nil && fi.Size() == j.FileSize {
-						continue
-					}
+	// Check free space
+	if !forceDownload {
+		if err := checkDiskSpace(manifestFiles, manifestChunks); err != nil {
+			log.Fatalf("%v, use -force to download anyway", err)
+		}
+	}
 
-					// Download chunk
-					chunkData, err := j.Download(downloadURLs[rand.Intn(len(downloadURLs))])
-					if err != nil {
-						log.Printf("Failed to download chunk %s: %v\n", j.GUID, err)
-						jobs <- j // requeue
-						continue
-					}
+	// Calculate space needed for all files
+	var remainingBytes int64
+	for _, file := range manifestFiles {
+		remainingBytes += int64(file.Size())
+	}
+	atomic.StoreInt64(&progress.filesTotal, int64(len(manifestFiles)))
+	atomic.StoreInt64(&progress.bytesTotal, remainingBytes)
 
-					// Write to disk
-					if err := ioutil.WriteFile(filePath, chunkData, 0644); err != nil {
-						log.Printf("Failed to write chunk %s: %v\n", j.GUID, err)
-						jobs <- j
-					}
-				}
-			}()
+	// Download and assemble files
+	download := &FileDownload{
+		files:          manifestFiles,
+		chunks:         manifestChunks,
+		plan:           plan,
+		checkedFiles:   checkedFiles,
+		writtenFiles:   make(map[string]string),
+		remainingBytes: remainingBytes,
+	}
+	start := time.Now()
+	names := orderFiles(manifestFiles, fileOrder, filePins)
+	if parallelManifests > 1 && len(manifests) > 1 {
+		downloadManifestsParallel(download, names, fileSources)
+	} else {
+		download.Run(names)
+	}
+	downloadedFiles, failedFiles := download.downloadedFiles, download.failedFiles+rejectedFiles
+	downloadedBytes, totalNetworkBytes := download.downloadedBytes, download.networkBytes
+
+	elapsed := time.Since(start)
+	infof("Downloaded %d files (%s, %s from network) in %s at %s.\n", downloadedFiles, formatBytes(downloadedBytes), formatBytes(totalNetworkBytes), elapsed.Round(time.Millisecond), formatSpeed(totalNetworkBytes, elapsed))
+	cacheBytes, localBytes := atomic.LoadInt64(&progress.cacheBytes), atomic.LoadInt64(&progress.localBytes)
+	infof("Served %s of chunk parts from cache and %s from the chunk folder, downloaded %s.\n", formatBytes(cacheBytes), formatBytes(localBytes), formatBytes(totalNetworkBytes))
+
+	// Files left undownloaded after a stop would all look corrupt, so only files finished in this run are known
+	stopped := stopRequested()
+
+	// Integrity check
+	corruptFiles := 0
+	var corruptList []ManifestFile
+	if !skipIntegrityCheck && !stopped {
+		corruptList = handleCorruptFiles(verifyFiles(manifestFiles, checkedFiles), manifestChunks)
+		corruptFiles += len(corruptList)
+	}
+
+	// Checksum file check
+	if len(fileChecksums) > 0 && !stopped {
+		corruptFiles += verifyChecksums(manifestFiles, fileChecksums)
+	}
+
+	flushCoalescedLogs()
+	reportMissingChunks()
+
+	exitCode := exitOK
+	if stopped {
+		errorf("Stopped before all files were downloaded, run splash again to resume.")
+		exitCode = exitFatal
+	} else if failedFiles > 0 {
+		errorf("Done, %d files failed to download.\n", failedFiles)
+		exitCode = exitDownloadFailed
+	} else if corruptFiles > 0 {
+		errorf("Done, %d files failed verification.\n", corruptFiles)
+		exitCode = exitCorrupt
+	} else {
+		// Only prune a complete install
+		if pruneOld {
+			infof("Pruned %d files not in the manifest.\n", pruneFiles(manifests, false))
 		}
 
-		// Wait for all goroutines
-		wg.Wait()
+		infof("Done!")
+	}
 
-		log.Println("Done!")
-		os.Exit(0)
+	buildVersions := make([]string, len(manifests))
+	for i, manifest := range manifests {
+		buildVersions[i] = manifest.BuildVersionString
 	}
 
-	log.Printf("Downloading %d files in %d chunks from %d manifests.\n", len(manifestFiles), len(manifestChunks), len(manifests))
+	// Record what's installed
+	if writeReceiptFile {
+		files := receiptFiles(manifestFiles, checkedFiles, corruptList, !skipIntegrityCheck && !stopped)
+		if err := writeReceipt(installPath, files, fileSources, buildVersions); err != nil {
+			warnf("Failed to write receipt: %v\n", err)
+		}
+	}
 
-	// Download and assemble files
-	for k, file := range manifestFiles {
-		if killSignal {
-			return
-		}
-
-		func() {
-			filePath := file.FileName
-
-			// Check if file already exists
-			if f, err := os.Open(filePath); err == nil {
-				// Compare checksum
-				equal, err := checkFile(f, file)
-				f.Close()
-				if err == nil && equal {
-					// Remove any trailing chunks
-					for _, chunkPart := range file.FileChunkParts {
-						chunkUsed(chunkPart.GUID)
-					}
+	end := time.Now()
+	summary := RunSummary{
+		Success:         exitCode == exitOK,
+		ExitCode:        exitCode,
+		BuildVersions:   buildVersions,
+		Start:           start,
+		End:             end,
+		Files:           len(manifestFiles),
+		DownloadedFiles: downloadedFiles,
+		FailedFiles:     failedFiles,
+		CorruptFiles:    corruptFiles,
+		DownloadedBytes: downloadedBytes,
+		NetworkBytes:    totalNetworkBytes,
+		CacheBytes:      cacheBytes,
+		LocalBytes:      localBytes,
+		Duration:        end.Sub(start).Seconds(),
+		Mirrors:         collectMirrorStats(),
+		MissingChunks:   collectMissingChunks(),
+		Corrupt:         corruptFileList(corruptList),
+	}
 
-					log.Printf("File %s found on disk!\n", file.FileName)
-					checkedFiles[k] = file
-					return
-				}
-			}
+	// Write run report
+	if statsPath != "" {
+		if err := writeStatsFile(statsPath, summary); err != nil {
+			warnf("Failed to write stats file: %v\n", err)
+		}
+	}
 
-			log.Printf("Downloading %s from %d chunks...\n", file.FileName, len(file.FileChunkParts))
+	notifyCompletion(summary)
 
-			// Create outfile
-			os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
-			outFile, err := os.Create(filePath)
-			if err != nil {
-				log.Printf("Failed to create %s: %v\n", filePath, err)
-				return
-			}
-			defer outFile.Close()
-
-			// Parse chunk parts
-			chunkPartCount := len(file.FileChunkParts)
-			chunkJobs := make([]ChunkJob, chunkPartCount)
-			jobs := make(chan ChunkJob, chunkPartCount)
-			for i, chunkPart := range file.FileChunkParts {
-				if chunkPart.OffsetInt != 0 || chunkPart.SizeInt != 0 {
-					chunkJobs[i] = ChunkJob{ID: i, Chunk: manifestChunks[chunkPart.GUID], Part: ChunkPart{Offset: chunkPart.OffsetInt, Size: chunkPart.SizeInt}}
-				} else {
-					chunkJobs[i] = ChunkJob{ID: i, Chunk: manifestChunks[chunkPart.GUID], Part: ChunkPart{Offset: readPackedUint32(chunkPart.Offset), Size: readPackedUint32(chunkPart.Size)}}
-				}
-				jobs <- chunkJobs[i]
-			}
+	if exitCode != exitOK {
+		if verifyState != nil {
+			verifyState.Close()
+		}
+		os.Exit(exitCode)
+	}
+}
 
-			results := make(chan ChunkJobResult, chunkPartCount)
-			orderedResults := make(chan ChunkJobResult, chunkPartCount)
-
-			// Order results as they come in
-			go func() {
-				resultsBuffer := make(map[int]ChunkJobResult)
-				for result := range results {
-					resultsBuffer[result.Job.ID] = result
-
-				loop:
-					if len(chunkJobs) > 0 {
-						if res, ok := resultsBuffer[chunkJobs[0].ID]; ok {
-							orderedResults <- res
-							chunkJobs = chunkJobs[1:]
-							delete(resultsBuffer, res.Job.ID)
-							goto loop
-						}
-					}
-				}
-			}()
+// Keep only the first n files sorted by path, and the chunks and checksums they use
+func limitManifestFiles(files map[string]ManifestFile, chunks map[string]Chunk, checksums map[string]string, n int) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-			// Spawn workers
-			for i := 0; i < workerCount; i++ {
-				go chunkWorker(jobs, results)
-			}
+	for _, name := range names[n:] {
+		delete(files, name)
+		delete(checksums, name)
+	}
 
-			// Handle results
-			for i := 0; i < chunkPartCount; i++ {
-				result := <-orderedResults
+	// Recount chunk parents for the remaining files
+	chunkCache.ResetParents()
+	for _, file := range files {
+		for _, c := range file.FileChunkParts {
+			chunkCache.AddParents(c.GUID, 1)
+		}
+	}
 
-				// Write chunk part to file
-				result.Reader.Seek(int64(result.Job.Part.Offset), io.SeekCurrent)
-				_, err := io.CopyN(outFile, result.Reader, int64(result.Job.Part.Size))
+	// Drop unused chunks
+	for guid := range chunks {
+		if chunkCache.Parents(guid) == 0 {
+			delete(chunks, guid)
+		}
+	}
+}
 
-				// Close reader
-				result.Reader.Close()
+// Download a single file, it's assembled in a temp path and only moved into place once complete and verified.
+// Returns the amount of bytes downloaded from the network.
+func downloadFile(file ManifestFile, manifestChunks map[string]Chunk) (int64, error) {
+	if err := checkSymlinkEscape(file.FileName); err != nil {
+		return 0, err
+	}
 
-				if err != nil {
-					log.Printf("Failed to write chunk %s to file %s: %v\n", result.Job.Chunk.GUID, file.FileName, err)
-					continue
-				}
-			}
-			close(jobs)
-			close(results)
-		}()
+	partialPath := tempPath(file.FileName)
+	trackPartial(partialPath)
+	defer untrackPartial(partialPath)
+
+	networkBytes, err := assembleFile(partialPath, file, manifestChunks)
+	if errors.Is(err, errMissingChunks) {
+		return networkBytes, fmt.Errorf("%w, left incomplete at %s", err, partialPath)
+	}
+	if err != nil {
+		storage.Remove(partialPath)
+		return networkBytes, err
 	}
 
-	// Integrity check
+	// Verify before moving into place
 	if !skipIntegrityCheck {
-		log.Println("Verifying file integrity...")
+		f, err := storage.Open(partialPath)
+		if err != nil {
+			return networkBytes, fmt.Errorf("failed to open: %w", err)
+		}
 
-		for k, file := range manifestFiles {
-			// Skip prechecked files
-			if _, ok := checkedFiles[k]; ok {
-				continue
-			}
+		equal, err := checkFile(f, file)
+		f.Close()
 
-			// Open file
-			f, err := os.Open(file.FileName)
-			if err != nil {
-				log.Printf("Failed to open %s: %v\n", file.FileName, err)
-				continue
-			}
+		if err != nil || !equal {
+			storage.Remove(partialPath)
+			return networkBytes, fmt.Errorf("failed verification")
+		}
+	}
 
-			// Hash file
-			equal, err := checkFile(f, file)
-			f.Close()
+	// Move complete file into place
+	storage.MkdirAll(filepath.Dir(file.FileName))
+	if err := storage.Rename(partialPath, file.FileName); err != nil {
+		storage.Remove(partialPath)
+		return networkBytes, fmt.Errorf("failed to move into place: %w", err)
+	}
 
-			if err != nil {
-				log.Printf("Failed to hash %s: %v\n", file.FileName, err)
+	// Remember the verification under the final path
+	if !skipIntegrityCheck && verifyState != nil {
+		if fi, err := storage.Stat(file.FileName); err == nil {
+			verifyState.MarkVerified(file.FileName, file.FileHash, fi)
+		}
+	}
+
+	return networkBytes, nil
+}
+
+// Assemble a file from its chunk parts, returns the amount of bytes downloaded from the network
+func assembleFile(filePath string, file ManifestFile, manifestChunks map[string]Chunk) (int64, error) {
+	// Create outfile
+	// Never write through an old partial file that's a symlink
+	storage.Remove(filePath)
+
+	storage.MkdirAll(filepath.Dir(filePath))
+	outFile, err := storage.Create(filePath, installFileMode(file))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create: %w", err)
+	}
+	defer outFile.Close()
+
+	// Preallocate outfile
+	if err := outFile.Truncate(int64(file.Size())); err != nil {
+		return 0, fmt.Errorf("failed to allocate: %w", err)
+	}
+
+	// Write through a memory mapping for large files, falling back to regular writes
+	var writer io.WriterAt = outFile
+	var unmap func() error
+	if f, ok := osFile(outFile); ok && mmapWrites && !lowMemory && file.Size() >= mmapMinSize && uint64(int(file.Size())) == file.Size() {
+		mapped, unmapFile, err := mmapFile(f, int64(file.Size()))
+		if err == nil {
+			writer = mapped
+			unmap = unmapFile
+		} else {
+			debugf("Failed to map %s, writing normally: %v\n", filePath, err)
+		}
+	}
+
+	// Parse chunk parts
+	chunkPartCount := len(file.FileChunkParts)
+	jobs := make(chan ChunkJob, chunkPartCount)
+	var fileOffset int64
+	for i, chunkPart := range file.FileChunkParts {
+		job := ChunkJob{ID: i, Chunk: manifestChunks[chunkPart.GUID], FileOffset: fileOffset}
+		if chunkPart.OffsetInt != 0 || chunkPart.SizeInt != 0 {
+			job.Part = ChunkPart{Offset: chunkPart.OffsetInt, Size: chunkPart.SizeInt}
+		} else {
+			job.Part = ChunkPart{Offset: readPackedUint32(chunkPart.Offset), Size: readPackedUint32(chunkPart.Size)}
+		}
+		fileOffset += int64(job.Part.Size)
+		jobs <- job
+	}
+
+	results := make(chan ChunkJobResult, chunkPartCount)
+
+	// Bound the parts fetched but not written yet
+	var window chan struct{}
+	if prefetchParts > 0 {
+		window = make(chan struct{}, prefetchParts)
+	}
+
+	// Spawn workers, no more than there are chunk parts
+	workers := workerCount
+	if workers > chunkPartCount {
+		workers = chunkPartCount
+	}
+	for i := 0; i < workers; i++ {
+		go chunkWorker(jobs, results, window)
+	}
+
+	// Handle results as they come in
+	var writeErr error
+	var networkBytes int64
+	failedParts := 0
+	missingParts := 0
+	freeSlot := func() {
+		if window != nil {
+			<-window
+		}
+	}
+	for i := 0; i < chunkPartCount; i++ {
+		result := <-results
+		networkBytes += result.NetworkBytes
+
+		// Chunk failed on every mirror
+		if result.Err != nil {
+			freeSlot()
+			errorf("Giving up on chunk %s for file %s: %v\n", result.Job.Chunk.GUID, file.FileName, result.Err)
+			recordMissingChunk(result.Job.Chunk.GUID, file.FileName)
+			missingParts++
+			continue
+		}
+
+		// Skip remaining parts once the disk is full
+		if writeErr != nil {
+			result.Reader.Close()
+			freeSlot()
+			continue
+		}
+
+		// Write chunk part to its place in the file
+		err := writeChunkPart(writer, result)
+
+		// Close reader
+		result.Reader.Close()
+		freeSlot()
+
+		if err != nil {
+			if isDiskFull(err) {
+				writeErr = fmt.Errorf("failed to write chunk %s: %w", result.Job.Chunk.GUID, err)
 				continue
 			}
 
-			if !equal {
-				log.Printf("File %s is corrupt\n", file.FileName)
-			}
+			errorf("Failed to write chunk %s to file %s: %v\n", result.Job.Chunk.GUID, file.FileName, err)
+			failedParts++
+			continue
 		}
 	}
+	close(jobs)
+	close(results)
+
+	if writeErr == nil && failedParts > 0 {
+		writeErr = fmt.Errorf("failed to write %d chunk parts", failedParts)
+	}
+	if unmap != nil {
+		if err := unmap(); err != nil && writeErr == nil {
+			writeErr = fmt.Errorf("failed to unmap: %w", err)
+		}
+	}
+	if writeErr == nil && missingParts > 0 {
+		writeErr = fmt.Errorf("%d chunk parts %w", missingParts, errMissingChunks)
+	}
 
-	log.Println("Done!")
+	return networkBytes, writeErr
 }
 
-func checkFile(f *os.File, file ManifestFile) (bool, error) {
-	// Parse expected hash
-	var hash []byte
-	if len(file.FileHash) == 40 {
-		hash, _ = hex.DecodeString(file.FileHash)
-	} else {
-		hash = readPackedData(file.FileHash)
+// Ask the user to pick one of multiple catalog elements
+func promptCatalogElement(catalog *Catalog) int {
+	infof("Catalog has %d elements:\n", len(catalog.Elements))
+	for i, e := range catalog.Elements {
+		fmt.Printf("  [%d] %s (%s) %s\n", i, e.AppName, e.LabelName, e.BuildVersion)
 	}
 
-	// Calculate file size
-	var totalSize uint32 = 0
-	for _, chunk := range file.FileChunkParts {
-		if chunk.SizeInt != 0 {
-			totalSize += chunk.SizeInt
-		} else {
-			totalSize += readPackedUint32(chunk.Size)
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("Select an element: ")
+
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Fatal("No catalog element selected, use -catalog-element to pick one")
+		}
+
+		i, err := catalog.FindElement(strings.TrimSpace(line))
+		if err == nil {
+			return i
 		}
+
+		fmt.Println(err)
+	}
+}
+
+// Write a raw chunk to the chunk folder unless it's already there
+func saveChunk(guid string, data []byte) {
+	// Only write each chunk once
+	savedChunksLock.Lock()
+	if savedChunks[guid] {
+		savedChunksLock.Unlock()
+		return
+	}
+	savedChunks[guid] = true
+	savedChunksLock.Unlock()
+
+	data, err := storedChunkData(data)
+	if err != nil {
+		warnf("Failed to save chunk %s: %v\n", guid, err)
+		return
+	}
+
+	filePath := filepath.Join(chunkPath, guid)
+	if fi, err := os.Stat(filePath); err == nil && fi.Size() == int64(len(data)) {
+		return
+	}
+
+	os.MkdirAll(chunkPath, os.ModePerm)
+	if err := writeFileAtomic(filePath, data); err != nil {
+		warnf("Failed to save chunk %s: %v\n", guid, err)
+	}
+}
+
+// Pick a random download url, avoiding the excluded one if possible
+func pickDownloadURL(exclude string) string {
+	if mirrorStrategy == mirrorLatency {
+		return pickFastMirror(exclude)
+	}
+
+	if len(downloadURLs) == 1 || exclude == "" {
+		return downloadURLs[rand.Intn(len(downloadURLs))]
+	}
+
+	i := rand.Intn(len(downloadURLs) - 1)
+	if downloadURLs[i] == exclude {
+		i = len(downloadURLs) - 1
+	}
+
+	return downloadURLs[i]
+}
+
+// Write a chunk part to its offset in the output file.
+// Chunk readers start at the beginning of the chunk data, which the part offset is relative to.
+func writeChunkPart(outFile io.WriterAt, result ChunkJobResult) error {
+	start, err := result.Reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := result.Reader.Seek(start+int64(result.Job.Part.Offset), io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(NewOffsetWriter(outFile, result.Job.FileOffset), result.Reader, int64(result.Job.Part.Size))
+	return err
+}
+
+// Check if a file is already on disk and complete.
+// With -prefer-local a matching size is enough, the hash is left to the integrity check.
+func fileOnDisk(file ManifestFile) bool {
+	if preferLocal {
+		fi, err := storage.Stat(file.FileName)
+		return err == nil && uint64(fi.Size()) == file.Size()
+	}
+
+	f, err := storage.Open(file.FileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	equal, err := checkFile(f, file)
+	return err == nil && equal
+}
+
+func checkFile(f StorageFile, file ManifestFile) (bool, error) {
+	// Parse expected hash
+	hash, err := file.Hash()
+	if err != nil {
+		return false, err
 	}
 
 	// Compare actual size
@@ -424,128 +1245,366 @@ func checkFile(f *os.File, file ManifestFile) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("failed to stat: %v", err)
 	}
-	if totalSize != uint32(fi.Size()) {
+	if file.Size() != uint64(fi.Size()) {
 		return false, nil
 	}
 
+	// Skip files verified by a previous run
+	if verifyState != nil && verifyState.IsVerified(f.Name(), file.FileHash, fi) {
+		return true, nil
+	}
+	if receiptMatches(f.Name(), file.FileHash, fi) {
+		return true, nil
+	}
+
 	// Calculate checksum
 	hasher := sha1.New()
-	_, err = io.Copy(hasher, f)
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false, err
+	}
 
 	// Compare checksum
-	return bytes.Equal(hasher.Sum(nil), hash), err
+	equal := bytes.Equal(hasher.Sum(nil), hash)
+	if equal && verifyState != nil && !strings.HasSuffix(f.Name(), partialSuffix) { // partial files are marked once in place
+		verifyState.MarkVerified(f.Name(), file.FileHash, fi)
+	}
+
+	return equal, nil
 }
 
-func chunkUsed(guid string) {
-	// Chunk was used once
-	chunkParentCount[guid]--
+// Parse a raw chunk, decompressing it if needed.
+// parseChunk takes ownership of reader: on error it is closed, otherwise closing the returned reader closes it.
+// Uncompressed chunks are read straight from reader, compressed ones are decompressed and reader is closed right away.
+// Decompressed data is backed by a pooled buffer and only valid until the returned reader is closed.
+func parseChunk(reader ReadSeekCloser) (ReadSeekCloser, []byte, error) {
+	chunkHeader, err := readChunkStart(reader)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	// Only check the SHA-1 if the header has one
+	checkSha := chunkHeader.Version >= chunkHeaderVersionSha && chunkHeader.HashType&chunkHashSha1 != 0
 
-	// Check if we still need to store chunk in cache
-	if chunkParentCount[guid] < 1 {
-		delete(chunkCache, guid)
+	// Decompress if needed
+	if chunkHeader.StoredAs == storedAsPlaintext {
+		if checkSha {
+			if err := checkChunkSha(reader, chunkHeader.SHAHash); err != nil {
+				reader.Close()
+				return nil, nil, err
+			}
+		}
+		return reader, nil, nil
+	}
+
+	newDecompressor, ok := chunkDecompressors[chunkHeader.StoredAs]
+	if !ok {
+		reader.Close()
+		return nil, nil, fmt.Errorf("got unknown chunk: %d", chunkHeader.StoredAs)
+	}
+	defer reader.Close()
+
+	// Create decompressor
+	decompressor, err := newDecompressor(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create decompressor: %v", err)
+	}
+
+	// Decompress entire chunk
+	buf := chunkBufferPool.Get().(*bytes.Buffer)
+	_, err = buf.ReadFrom(decompressor)
+	decompressor.Close()
+	if err != nil {
+		buf.Reset()
+		chunkBufferPool.Put(buf)
+		return nil, nil, fmt.Errorf("failed to decompress: %v", err)
+	}
+
+	if checkSha {
+		if sum := sha1.Sum(buf.Bytes()); sum != chunkHeader.SHAHash {
+			buf.Reset()
+			chunkBufferPool.Put(buf)
+			return nil, nil, fmt.Errorf("header sha mismatch, expected %x got %x", chunkHeader.SHAHash, sum)
+		}
 	}
+
+	// Set reader to decompressed data
+	return NewPooledByteCloser(buf), buf.Bytes(), nil
 }
 
-func parseChunk(reader ReadSeekCloser) (ReadSeekCloser, []byte, error) {
-	// Read chunk header
+// Parse a chunk used only once without decompressing it up front.
+// Data is decompressed as it's read and the reader only seeks forwards, so only the part needed is decompressed.
+// The header SHA-1 can't be checked without reading everything, the file hash still catches corruption.
+func parseChunkStream(reader ReadSeekCloser) (ReadSeekCloser, error) {
+	chunkHeader, err := readChunkStart(reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	if chunkHeader.StoredAs == storedAsPlaintext {
+		return reader, nil
+	}
+
+	newDecompressor, ok := chunkDecompressors[chunkHeader.StoredAs]
+	if !ok {
+		reader.Close()
+		return nil, fmt.Errorf("got unknown chunk: %d", chunkHeader.StoredAs)
+	}
+
+	decompressor, err := newDecompressor(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("failed to create decompressor: %v", err)
+	}
+
+	return NewStreamReader(decompressor, reader), nil
+}
+
+// Read and check a chunk header, leaving the reader at the start of the chunk data
+func readChunkStart(reader ReadSeekCloser) (ChunkHeader, error) {
 	chunkHeader, err := readChunkHeader(reader)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read header: %v", err)
+		return chunkHeader, fmt.Errorf("failed to read header: %v", err)
 	}
 
-	// Decompress if needed
-	if chunkHeader.StoredAs == 0 {
-		return reader, nil, nil
-	} else if chunkHeader.StoredAs == 1 {
-		// Create decompressor
-		zlibReader, err := zlib.NewReader(reader)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create decompressor: %v", err)
+	// Older headers are shorter and newer ones longer, the data starts after the header
+	if chunkHeader.HeaderSize != chunkHeaderSize {
+		if _, err := reader.Seek(int64(chunkHeader.HeaderSize), io.SeekStart); err != nil {
+			return chunkHeader, fmt.Errorf("failed to skip header: %v", err)
 		}
+	}
+
+	if chunkHeader.Version >= chunkHeaderVersionSha && chunkHeader.HashType&^chunkHashBoth != 0 {
+		return chunkHeader, fmt.Errorf("got unknown hash type: %d", chunkHeader.HashType)
+	}
+
+	return chunkHeader, nil
+}
+
+// Check the rest of a reader against the SHA-1 from a chunk header, rewinding it afterwards
+func checkChunkSha(r io.ReadSeeker, expected [sha1.Size]byte) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return fmt.Errorf("failed to hash: %v", err)
+	}
 
-		// Decompress entire chunk
-		chunkData, err := ioutil.ReadAll(zlibReader)
-		zlibReader.Close()
+	var sum [sha1.Size]byte
+	copy(sum[:], hasher.Sum(nil))
+	if sum != expected {
+		return fmt.Errorf("header sha mismatch, expected %x got %x", expected, sum)
+	}
+
+	_, err = r.Seek(start, io.SeekStart)
+	return err
+}
+
+// Read a predownloaded chunk from the chunk folder, verifying it if its SHA-1 is known
+func readDiskChunk(chunk Chunk) (ReadSeekCloser, error) {
+	chunkReader, err := openDiskChunk(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	if chunk.Sha == "" {
+		return chunkReader, nil
+	}
+
+	// Streamed chunks can't be rewound, hash one pass and hand out a second one
+	if lowMemory {
+		err := chunk.checkSum(chunkReader)
+		chunkReader.Close()
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to decompress: %v", err)
+			return nil, err
 		}
+		return openDiskChunk(chunk)
+	}
 
-		// Set reader to decompressed data
-		return NewByteCloser(chunkData), chunkData, nil
+	// Verify chunk data
+	if err := chunk.checkData(chunkReader); err != nil {
+		chunkReader.Close()
+		return nil, err
 	}
 
-	return nil, nil, fmt.Errorf("got unknown chunk: %d", chunkHeader.StoredAs)
+	return chunkReader, nil
 }
 
-func chunkWorker(jobs chan ChunkJob, results chan<- ChunkJobResult) {
-	for j := range jobs {
-		var chunkReader ReadSeekCloser
-		cacheLock.Lock()
-		_, ok := chunkCache[j.Chunk.GUID]
-		cacheLock.Unlock()
-		if ok {
-			// Read from cache
-			chunkReader = NewByteCloser(chunkCache[j.Chunk.GUID])
-		} else if rawChunkReader, err := os.Open(filepath.Join(chunkPath, j.Chunk.GUID)); err == nil {
-			if err != nil {
-				log.Printf("Failed to open chunk %s from disk: %v\n", j.Chunk.GUID, err)
-				jobs <- j
-				continue
-			}
+// Open and parse a chunk from the chunk folder
+func openDiskChunk(chunk Chunk) (ReadSeekCloser, error) {
+	rawChunkReader, err := openFile(filepath.Join(chunkPath, chunk.GUID), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
 
-			// Parse chunk
-			var decompressedData []byte
-			chunkReader, decompressedData, err = parseChunk(rawChunkReader)
+	if verifyRollingHash {
+		if err := chunk.checkRollingHash(rawChunkReader); err != nil {
+			rawChunkReader.Close()
+			return nil, err
+		}
+	}
 
-			// Close original file reader if we got decompressed data
-			if len(decompressedData) > 0 || err != nil {
-				rawChunkReader.Close()
-			}
+	// Parse chunk, closing chunkReader closes the file
+	chunkReader, err := parseStoredChunk(rawChunkReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %v", err)
+	}
+
+	return chunkReader, nil
+}
+
+// Fetch a chunk from disk or the network, caching it if it's needed again.
+// Returns the reader and the amount of bytes downloaded from the network.
+func fetchChunk(j *ChunkJob) (ReadSeekCloser, int64, error) {
+	// Read from disk
+	diskReader, err := readDiskChunk(j.Chunk)
+	if err == nil {
+		debugf("Chunk %s read from disk.\n", j.Chunk.GUID)
+		atomic.AddInt64(&progress.localBytes, int64(j.Part.Size))
+		return diskReader, 0, nil
+	}
+	if !os.IsNotExist(err) {
+		warnf("Chunk %s on disk is unusable, downloading instead: %v\n", j.Chunk.GUID, err)
+	}
+
+	downloadURL := pickDownloadURL(j.FailedURL)
+
+	// Download only the needed part of chunks used once
+	if rangeRequests && (lowMemory || chunkCache.Parents(j.Chunk.GUID) == 1) && !saveChunks {
+		debugf("Downloading part of chunk %s from %s...\n", j.Chunk.GUID, downloadURL)
+		chunkReader, networkBytes, err := j.Chunk.DownloadPart(downloadURL, j.Part)
+		recordMirrorRequest(downloadURL, networkBytes, err)
+		if err != nil {
+			coalescef(levelWarn, chunkFailureKey(downloadURL, err), "Failed to download chunk %s: %v\n", j.Chunk.GUID, err)
+			j.FailedURL = downloadURL
+		}
+		return chunkReader, networkBytes, err
+	}
+
+	// Download chunk
+	debugf("Downloading chunk %s from %s...\n", j.Chunk.GUID, downloadURL)
+	rawChunkData, err := j.Chunk.Download(downloadURL)
+	recordMirrorRequest(downloadURL, int64(len(rawChunkData)), err)
+	if err != nil {
+		coalescef(levelWarn, chunkFailureKey(downloadURL, err), "Failed to download chunk %s: %v\n", j.Chunk.GUID, err)
+		j.FailedURL = downloadURL
+		return nil, 0, err
+	}
+	networkBytes := int64(len(rawChunkData))
+
+	// Catch the wrong chunk being served
+	if verifyRollingHash {
+		if err := j.Chunk.checkRollingHash(NewByteCloser(rawChunkData)); err != nil {
+			warnf("Chunk %s from %s is wrong: %v\n", j.Chunk.GUID, downloadURL, err)
+			j.FailedURL = downloadURL
+			return nil, networkBytes, err
+		}
+	}
+
+	// Persist chunk for later runs
+	if saveChunks {
+		saveChunk(j.Chunk.GUID, rawChunkData)
+	}
+
+	// Decompress chunks used once as they're read, nothing is cached
+	if lowMemory || chunkCache.Parents(j.Chunk.GUID) <= 1 {
+		chunkReader, err := parseChunkStream(NewByteCloser(rawChunkData))
+		if err != nil {
+			warnf("Failed to parse chunk %s: %v\n", j.Chunk.GUID, err)
+			return nil, networkBytes, err
+		}
+		return chunkReader, networkBytes, nil
+	}
+
+	// Parse chunk
+	chunkReader, chunkData, err := parseChunk(NewByteCloser(rawChunkData))
+	if err != nil {
+		warnf("Failed to parse chunk %s: %v\n", j.Chunk.GUID, err)
+		return nil, networkBytes, err
+	}
+
+	// Store in cache if needed later
+	if chunkCache.Parents(j.Chunk.GUID) > 1 {
+		if cacheCompressed {
+			chunkCache.Store(j.Chunk.GUID, rawChunkData) // header included, parsed again on every hit
+		} else if len(chunkData) > 0 {
+			chunkCache.Store(j.Chunk.GUID, append([]byte(nil), chunkData...)) // copy out of pooled buffer
+		} else if start, err := chunkReader.Seek(0, io.SeekCurrent); err == nil {
+			// Stored uncompressed, the reader is past the header, which isn't always chunkHeaderSize long
+			chunkCache.Store(j.Chunk.GUID, rawChunkData[start:])
+		}
+	}
+
+	return chunkReader, networkBytes, nil
+}
+
+// Workers take a slot in window, if set, before fetching a chunk part. It's freed once the part is written,
+// so no more than the window size of fetched parts wait to be written.
+func chunkWorker(jobs chan ChunkJob, results chan<- ChunkJobResult, window chan struct{}) {
+	requeue := func(j ChunkJob) {
+		if window != nil {
+			<-window
+		}
+		jobs <- j
+	}
+
+	for j := range jobs {
+		if window != nil {
+			window <- struct{}{}
+		}
 
+		var chunkReader ReadSeekCloser
+		var networkBytes int64
+		var cachedData []byte
+		var ok bool
+		if !lowMemory {
+			cachedData, ok = chunkCache.Lookup(j.Chunk.GUID)
+		}
+		if ok && cacheCompressed {
+			// Read from cache, decompressing again
+			debugf("Chunk %s read from compressed cache.\n", j.Chunk.GUID)
+			var err error
+			chunkReader, _, err = parseChunk(NewByteCloser(cachedData))
 			if err != nil {
-				log.Printf("Failed to parse chunk %s from disk: %v\n", j.Chunk.GUID, err)
-				jobs <- j
+				warnf("Failed to parse cached chunk %s: %v\n", j.Chunk.GUID, err)
+				chunkCache.Delete(j.Chunk.GUID)
+				requeue(j)
 				continue
 			}
+			atomic.AddInt64(&progress.cacheBytes, int64(j.Part.Size))
+		} else if ok {
+			// Read from cache
+			debugf("Chunk %s read from cache.\n", j.Chunk.GUID)
+			atomic.AddInt64(&progress.cacheBytes, int64(j.Part.Size))
+			chunkReader = NewByteCloser(cachedData)
 		} else {
-			// Download chunk
-			rawChunkData, err := j.Chunk.Download(downloadURLs[rand.Intn(len(downloadURLs))])
-			if err != nil {
-				log.Printf("Failed to download chunk %s: %v\n", j.Chunk.GUID, err)
-				jobs <- j // requeue
-				continue
+			var err error
+			atomic.AddInt64(&progress.activeWorkers, 1)
+			chunkReader, networkBytes, err = fetchChunk(&j)
+			atomic.AddInt64(&progress.activeWorkers, -1)
+			if !lowMemory {
+				chunkCache.Fetched(j.Chunk.GUID)
 			}
-
-			// Create new reader
-			chunkReader = NewByteCloser(rawChunkData)
-
-			// Parse chunk
-			var chunkData []byte
-			chunkReader, chunkData, err = parseChunk(chunkReader)
 			if err != nil {
-				log.Printf("Failed to parse chunk %s: %v\n", j.Chunk.GUID, err)
-				jobs <- j
-				continue
-			}
-
-			// Store in cache if needed later
-			cacheLock.Lock()
-			if chunkParentCount[j.Chunk.GUID] > 1 {
-				if len(chunkData) > 0 {
-					chunkCache[j.Chunk.GUID] = chunkData
-				} else {
-					chunkCache[j.Chunk.GUID] = rawChunkData[62:] // chunkData still contains header here
+				j.Attempts++
+				if continueOnMissing && (j.Attempts >= chunkAttemptLimit() || isMissingChunk(j.Chunk.GUID)) {
+					chunkCache.Used(j.Chunk.GUID)
+					results <- ChunkJobResult{Job: j, Err: err}
+					continue
 				}
+				requeue(j)
+				continue
 			}
-			cacheLock.Unlock()
 		}
 
 		// Chunk was used once
-		cacheLock.Lock()
-		chunkUsed(j.Chunk.GUID)
-		cacheLock.Unlock()
+		chunkCache.Used(j.Chunk.GUID)
 
 		// Pass result
-		results <- ChunkJobResult{Job: j, Reader: chunkReader}
+		results <- ChunkJobResult{Job: j, Reader: chunkReader, NetworkBytes: networkBytes}
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Flags keep their defaults, the testing flags are parsed along with them
+	parseFlags()
+	os.Exit(m.Run())
+}
+
+// Logger keeping every line for tests to check
+type logRecorder struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (l *logRecorder) Printf(format string, v ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// Check if a line containing s was logged
+func (l *logRecorder) contains(s string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Record log output until the test ends.
+// Coalesced messages are flushed around the swap, so no window ending later logs to the wrong logger.
+func recordLogs(t *testing.T) *logRecorder {
+	recorder := new(logRecorder)
+	flushCoalescedLogs()
+	previous := logger
+	logger = recorder
+	t.Cleanup(func() {
+		flushCoalescedLogs()
+		logger = previous
+	})
+
+	return recorder
+}
+
+// Set a global for the duration of a test
+func setGlobal(t *testing.T, p *string, value string) {
+	previous := *p
+	*p = value
+	t.Cleanup(func() { *p = previous })
+}
+
+// Clear a stop requested during a test
+func clearStop(t *testing.T) {
+	t.Cleanup(func() { atomic.StoreInt32(&stopping, 0) })
+}
+
+// Data of n bytes that differs between seeds
+func testData(seed byte, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = seed + byte(i*7)
+	}
+
+	return data
+}
+
+// Test chunk GUIDs, one per index
+func testGUID(i int) string {
+	return fmt.Sprintf("%032X", i+1)
+}
+
+// Describe a file made of whole chunks, written to path
+func testFile(path string, guids []string, data [][]byte) ManifestFile {
+	file := ManifestFile{FileName: path}
+	hasher := sha1.New()
+	for i, guid := range guids {
+		hasher.Write(data[i])
+		file.FileChunkParts = append(file.FileChunkParts, ManifestFileChunkPart{GUID: guid, SizeInt: uint32(len(data[i]))})
+	}
+	file.FileHash = hex.EncodeToString(hasher.Sum(nil))
+
+	return file
+}
+
+// Write raw chunks to a chunk folder and use it for the test
+func useTestChunkDir(t *testing.T, chunks map[string][]byte) map[string]Chunk {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "chunks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &chunkPath, dir)
+
+	manifestChunks := make(map[string]Chunk, len(chunks))
+	for guid, raw := range chunks {
+		if err := ioutil.WriteFile(filepath.Join(dir, guid), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifestChunks[guid] = Chunk{GUID: guid, FileSize: int64(len(raw))}
+	}
+
+	return manifestChunks
+}
+
+// Use a new install folder for the test
+func useTestInstallDir(t *testing.T) string {
+	dir := t.TempDir()
+	setGlobal(t, &installPath, dir)
+
+	return dir
+}
+
+// In-memory io.WriterAt for assembling files
+type memWriterAt struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if off < 0 || off+int64(len(p)) > int64(len(w.data)) {
+		return 0, fmt.Errorf("write of %d bytes at %d outside of %d bytes", len(p), off, len(w.data))
+	}
+
+	return copy(w.data[off:], p), nil
+}
+
+// Split want into parts of size bytes, each taken from the middle of its own chunk, as results in file order
+func testPartResults(want []byte, size int) []ChunkJobResult {
+	var results []ChunkJobResult
+	for offset := 0; offset < len(want); offset += size {
+		end := offset + size
+		if end > len(want) {
+			end = len(want)
+		}
+
+		// The part starts after some other data in the chunk
+		chunkData := append(testData(byte(offset), 13), want[offset:end]...)
+		chunkData = append(chunkData, testData(byte(offset), 5)...)
+		results = append(results, ChunkJobResult{
+			Job: ChunkJob{
+				ID:         len(results),
+				Part:       ChunkPart{Offset: 13, Size: uint32(end - offset)},
+				FileOffset: int64(offset),
+			},
+			Reader: NewByteCloser(chunkData),
+		})
+	}
+
+	return results
+}
+
+func TestWriteChunkPartReverse(t *testing.T) {
+	want := testData(9, 10000)
+	results := testPartResults(want, 999)
+
+	w := &memWriterAt{data: make([]byte, len(want))}
+	for i := len(results) - 1; i >= 0; i-- {
+		if err := writeChunkPart(w, results[i]); err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+	}
+
+	if !bytes.Equal(w.data, want) {
+		t.Fatal("parts written in reverse order assembled a different file")
+	}
+}
+
+func TestWriteChunkPartScrambled(t *testing.T) {
+	want := testData(5, 50000)
+	results := testPartResults(want, 777)
+
+	// Complete the parts in a shuffled order, several at once
+	w := &memWriterAt{data: make([]byte, len(want))}
+	order := rand.New(rand.NewSource(1)).Perm(len(results))
+	var wg sync.WaitGroup
+	errs := make(chan error, len(results))
+	for _, i := range order {
+		wg.Add(1)
+		go func(result ChunkJobResult) {
+			defer wg.Done()
+			if err := writeChunkPart(w, result); err != nil {
+				errs <- err
+			}
+		}(results[i])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.data, want) {
+		t.Fatal("parts written in scrambled order assembled a different file")
+	}
+}
+
+// Count the open file descriptors of the process, where the os lists them
+func openFDs(t *testing.T) int {
+	t.Helper()
+
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files can't be counted here")
+	}
+
+	return len(fds)
+}
+
+func TestUncompressedDiskChunksClosed(t *testing.T) {
+	const chunkCount = 200
+	dir := useTestInstallDir(t)
+
+	// Every chunk stored uncompressed, one file of all of them
+	raw := make(map[string][]byte, chunkCount)
+	guids := make([]string, chunkCount)
+	data := make([][]byte, chunkCount)
+	for i := range guids {
+		guids[i] = testGUID(i)
+		data[i] = testData(byte(i), 512)
+		raw[guids[i]] = makeTestChunk(t, data[i], storedAsPlaintext, chunkHeaderSize, 0)
+	}
+	chunks := useTestChunkDir(t, raw)
+
+	path := filepath.Join(dir, "file.bin")
+	file := testFile(path, guids, data)
+	d := &FileDownload{
+		files:        map[string]ManifestFile{path: file},
+		chunks:       chunks,
+		checkedFiles: make(map[string]ManifestFile),
+		writtenFiles: make(map[string]string),
+	}
+
+	before := openFDs(t)
+	d.Run([]string{path})
+	if d.downloadedFiles != 1 {
+		t.Fatalf("file wasn't assembled, %d failed", d.failedFiles)
+	}
+
+	if after := openFDs(t); after > before {
+		t.Fatalf("%d files left open after reading %d uncompressed chunks", after-before, chunkCount)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errStalled = errors.New("download stalled")
+
+// StallReader cancels a transfer when fewer than minSpeed bytes per second are read over a window
+type StallReader struct {
+	r        io.Reader
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	window   time.Duration
+	minBytes int64
+	read     int64
+	stalled  int32
+}
+
+func (sr *StallReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+
+	// Push the deadline back once enough bytes arrived in this window
+	if atomic.AddInt64(&sr.read, int64(n)) >= sr.minBytes {
+		atomic.StoreInt64(&sr.read, 0)
+		sr.timer.Reset(sr.window)
+	}
+
+	if err != nil && atomic.LoadInt32(&sr.stalled) == 1 {
+		err = errStalled
+	}
+
+	return n, err
+}
+
+// Stop stops the stall detector
+func (sr *StallReader) Stop() {
+	sr.timer.Stop()
+}
+
+func NewStallReader(r io.Reader, cancel context.CancelFunc, minSpeed int64, window time.Duration) *StallReader {
+	sr := &StallReader{
+		r:        r,
+		cancel:   cancel,
+		window:   window,
+		minBytes: minSpeed * int64(window/time.Second),
+	}
+
+	sr.timer = time.AfterFunc(window, func() {
+		atomic.StoreInt32(&sr.stalled, 1)
+		sr.cancel()
+	})
+
+	return sr
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// An append-only state file of one json object per line, the chunk and verification states are built on it.
+// Later lines replace earlier ones, so entries are only ever appended.
+type stateFile struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex // held by the state using the file while it reads or appends
+}
+
+// Open a state file in dir, creating both if needed, and pass every previous line to read.
+// Lines cut off by an interrupted write are passed too, read ignores them by failing to unmarshal.
+func openStateFile(dir string, name string, read func(line []byte)) (*stateFile, error) {
+	os.MkdirAll(dir, os.ModePerm)
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		read(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Terminate a cut off line so new entries start on their own line
+	if fi, err := file.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			file.Write([]byte{'\n'})
+		}
+	}
+
+	return &stateFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Append an entry as a line of its own
+func (s *stateFile) append(entry interface{}) error {
+	return s.encoder.Encode(entry)
+}
+
+// Close closes the state file
+func (s *stateFile) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkStateCutOffLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, chunkStateFile)
+
+	// An interrupted write left half an entry behind
+	if err := ioutil.WriteFile(path, []byte(`{"guid":"A","size":1}`+"\n"+`{"guid":"B","si`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := openChunkState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.IsVerified("A", 1) || state.IsVerified("B", 2) {
+		t.Fatal("previous entries read wrong")
+	}
+	state.MarkVerified("C", 3)
+	state.MarkVerified("A", 4)
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// New entries start on their own line, later ones replacing earlier ones
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); len(lines) != 4 || lines[2] != `{"guid":"C","size":3}` {
+		t.Fatalf("got state file %q", data)
+	}
+
+	state, err = openChunkState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if !state.IsVerified("A", 4) || state.IsVerified("A", 1) || !state.IsVerified("C", 3) {
+		t.Fatal("entries not read back")
+	}
+}
+
+func TestVerifyStateCutOffLine(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, verifyStateFile), []byte(`{"path":"a","ha`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "file.bin")
+	if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := openVerifyState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.MarkVerified(file, "hash", fi)
+	state.Close()
+
+	state, err = openVerifyState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if !state.IsVerified(file, "hash", fi) || state.IsVerified(file, "other", fi) {
+		t.Fatal("entry written after a cut off line not read back")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// MirrorStats defines the requests made to a single download url
+type MirrorStats struct {
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	Bytes    int64 `json:"bytes"`
+}
+
+var mirrorStats = make(map[string]*MirrorStats)
+var mirrorStatsLock sync.Mutex
+
+// Record a chunk request to a download url
+func recordMirrorRequest(url string, bytes int64, err error) {
+	mirrorStatsLock.Lock()
+	defer mirrorStatsLock.Unlock()
+
+	stats, ok := mirrorStats[url]
+	if !ok {
+		stats = new(MirrorStats)
+		mirrorStats[url] = stats
+	}
+
+	stats.Requests++
+	stats.Bytes += bytes
+	atomic.AddInt64(&progress.networkBytes, bytes)
+	if err != nil {
+		stats.Failures++
+	}
+}
+
+// Snapshot the per mirror stats
+func collectMirrorStats() map[string]MirrorStats {
+	mirrorStatsLock.Lock()
+	defer mirrorStatsLock.Unlock()
+
+	stats := make(map[string]MirrorStats, len(mirrorStats))
+	for url, s := range mirrorStats {
+		stats[url] = *s
+	}
+
+	return stats
+}
+
+// Write the run summary to -stats-file
+func writeStatsFile(path string, summary RunSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
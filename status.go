@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How many warnings and errors the status endpoint keeps
+const statusRecentErrors = 20
+
+// How far back the current throughput is measured
+const statusThroughputWindow = 10 * time.Second
+
+// Status defines the progress served by -status-addr
+type Status struct {
+	FilesDone     int64    `json:"filesDone"`
+	FilesFailed   int64    `json:"filesFailed"`
+	FilesTotal    int64    `json:"filesTotal"`
+	BytesDone     int64    `json:"bytesDone"`
+	BytesTotal    int64    `json:"bytesTotal"`
+	NetworkBytes  int64    `json:"networkBytes"`
+	CacheBytes    int64    `json:"cacheBytes"` // chunk part bytes served from the in-memory cache
+	LocalBytes    int64    `json:"localBytes"` // chunk part bytes read from -chunk-dir
+	Throughput    int64    `json:"throughput"` // network bytes per second
+	ActiveWorkers int64    `json:"activeWorkers"`
+	RecentErrors  []string `json:"recentErrors"`
+}
+
+// Progress counters, updated atomically
+var progress struct {
+	filesDone     int64
+	filesFailed   int64
+	filesTotal    int64
+	bytesDone     int64
+	bytesTotal    int64
+	networkBytes  int64
+	cacheBytes    int64
+	localBytes    int64
+	activeWorkers int64
+}
+
+// Recent warnings and errors
+var recentErrors []string
+var recentErrorsLock sync.Mutex
+
+// Network byte samples for the throughput, one per second
+var throughputSamples []int64
+var throughputLock sync.Mutex
+
+func recordRecentError(message string) {
+	recentErrorsLock.Lock()
+	defer recentErrorsLock.Unlock()
+
+	recentErrors = append(recentErrors, strings.TrimSpace(message))
+	if len(recentErrors) > statusRecentErrors {
+		recentErrors = recentErrors[len(recentErrors)-statusRecentErrors:]
+	}
+}
+
+// Count a file as done for the status endpoint
+func fileDone(file ManifestFile) {
+	atomic.AddInt64(&progress.filesDone, 1)
+	atomic.AddInt64(&progress.bytesDone, int64(file.Size()))
+}
+
+// Serve the progress as json on addr
+func serveStatus(addr string) {
+	// Sample network bytes
+	go func() {
+		samples := int(statusThroughputWindow / time.Second)
+		for range time.Tick(time.Second) {
+			throughputLock.Lock()
+			throughputSamples = append(throughputSamples, atomic.LoadInt64(&progress.networkBytes))
+			if len(throughputSamples) > samples+1 {
+				throughputSamples = throughputSamples[1:]
+			}
+			throughputLock.Unlock()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentStatus())
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			errorf("Failed to serve status on %s: %v\n", addr, err)
+		}
+	}()
+
+	infof("Serving status on %s.\n", addr)
+}
+
+// Snapshot the progress counters
+func currentStatus() Status {
+	status := Status{
+		FilesDone:     atomic.LoadInt64(&progress.filesDone),
+		FilesFailed:   atomic.LoadInt64(&progress.filesFailed),
+		FilesTotal:    atomic.LoadInt64(&progress.filesTotal),
+		BytesDone:     atomic.LoadInt64(&progress.bytesDone),
+		BytesTotal:    atomic.LoadInt64(&progress.bytesTotal),
+		NetworkBytes:  atomic.LoadInt64(&progress.networkBytes),
+		CacheBytes:    atomic.LoadInt64(&progress.cacheBytes),
+		LocalBytes:    atomic.LoadInt64(&progress.localBytes),
+		ActiveWorkers: atomic.LoadInt64(&progress.activeWorkers),
+	}
+
+	throughputLock.Lock()
+	if n := len(throughputSamples); n > 1 {
+		status.Throughput = (throughputSamples[n-1] - throughputSamples[0]) / int64(n-1)
+	}
+	throughputLock.Unlock()
+
+	recentErrorsLock.Lock()
+	status.RecentErrors = append([]string{}, recentErrors...)
+	recentErrorsLock.Unlock()
+
+	return status
+}
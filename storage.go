@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// StorageFile is a file opened through a Storage, *os.File satisfies it
+type StorageFile interface {
+	io.Reader
+	io.WriterAt
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// Storage is where downloaded files are assembled and installed
+type Storage interface {
+	Create(path string, perm os.FileMode) (StorageFile, error)
+	Open(path string) (StorageFile, error)
+	Stat(path string) (os.FileInfo, error)
+	Rename(oldPath string, newPath string) error
+	Remove(path string) error
+	MkdirAll(path string) error
+}
+
+var storage Storage = osStorage{}
+
+// Storage on the local file system
+type osStorage struct{}
+
+func (osStorage) Create(path string, perm os.FileMode) (StorageFile, error) {
+	f, err := openFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (osStorage) Open(path string) (StorageFile, error) {
+	f, err := openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (osStorage) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// Rename falls back to copying when the paths are on different volumes
+func (osStorage) Rename(oldPath string, newPath string) error {
+	return moveFile(oldPath, newPath)
+}
+
+func (osStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (osStorage) MkdirAll(path string) error {
+	return os.MkdirAll(path, dirMode)
+}
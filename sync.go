@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"sync"
+)
+
+// SyncPlan defines how the files on disk differ from the manifest
+type SyncPlan struct {
+	Unchanged map[string]bool
+	Changed   []string
+	Missing   []string
+}
+
+// Hash every file on disk up front to plan which ones need downloading
+func planSync(files map[string]ManifestFile) SyncPlan {
+	plan := SyncPlan{Unchanged: make(map[string]bool)}
+	var planLock sync.Mutex
+
+	pending := make([]ManifestFile, 0, len(files))
+	for _, file := range files {
+		pending = append(pending, file)
+	}
+
+	parallelFiles(pending, func(file ManifestFile) {
+		_, statErr := storage.Stat(file.FileName)
+		present := statErr == nil && fileOnDisk(file)
+
+		planLock.Lock()
+		defer planLock.Unlock()
+
+		switch {
+		case present:
+			plan.Unchanged[file.FileName] = true
+		case os.IsNotExist(statErr):
+			plan.Missing = append(plan.Missing, file.FileName)
+		default:
+			plan.Changed = append(plan.Changed, file.FileName)
+		}
+	})
+
+	sort.Strings(plan.Changed)
+	sort.Strings(plan.Missing)
+
+	return plan
+}
+
+// Report what a sync is about to do
+func printSyncPlan(plan SyncPlan) {
+	infof("Sync: %d files unchanged, %d changed, %d missing.\n", len(plan.Unchanged), len(plan.Changed), len(plan.Missing))
+
+	for _, path := range plan.Changed {
+		infof("Changed: %s\n", path)
+	}
+	for _, path := range plan.Missing {
+		infof("Missing: %s\n", path)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Path a file is written to before being moved into place.
+// Without -tempdir it's next to the final path, so the move is a cheap rename on the same volume.
+func tempPath(path string) string {
+	if tempDir == "" {
+		return path + partialSuffix
+	}
+
+	// Flatten into the temp folder, the hash keeps files with the same name apart
+	hash := sha1.Sum([]byte(path))
+	return filepath.Join(tempDir, filepath.Base(path)+"."+hex.EncodeToString(hash[:4])+partialSuffix)
+}
+
+// Move a file into place, copying it when the temp folder is on another volume
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// Check if a rename failed because source and destination are on different volumes
+func isCrossDevice(err error) bool {
+	for _, target := range crossDeviceErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Copy a file next to dst first so dst is never left half written
+func copyFile(src string, dst string) error {
+	in, err := openFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	partialPath := dst + partialSuffix
+	trackPartial(partialPath)
+	defer untrackPartial(partialPath)
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	os.Remove(partialPath)
+	out, err := openFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(partialPath)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	if err := os.Rename(partialPath, dst); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	return nil
+}
@@ -1,7 +1,35 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 func reverse(s []byte) {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
 	}
 }
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatSpeed(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return formatBytes(0) + "/s"
+	}
+
+	return formatBytes(int64(float64(n)/elapsed.Seconds())) + "/s"
+}
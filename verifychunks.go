@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Verify the chunks of the chunk folder against the manifest without downloading anything, returns the exit code
+func verifyChunkDir(chunks map[string]Chunk) int {
+	infof("Verifying %d chunks in %s...\n", len(chunks), chunkPath)
+	start := time.Now()
+
+	// Build job queue
+	jobs := make(chan Chunk, len(chunks))
+	for _, chunk := range chunks {
+		jobs <- chunk
+	}
+	close(jobs)
+
+	var missing, corrupt int
+	var countLock sync.Mutex
+
+	// Workers
+	var wg sync.WaitGroup
+	for i := 0; i < chunkWorkerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				if stopRequested() {
+					return
+				}
+
+				// Parse, decompress and verify
+				reader, err := readDiskChunk(chunk)
+				if err == nil {
+					reader.Close()
+					continue
+				}
+
+				countLock.Lock()
+				if os.IsNotExist(err) {
+					debugf("Chunk %s is missing.\n", chunk.GUID)
+					missing++
+				} else {
+					errorf("Chunk %s is corrupt: %v\n", chunk.GUID, err)
+					corrupt++
+				}
+				countLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stopRequested() {
+		return exitFatal
+	}
+
+	infof("%d of %d chunks verified in %s, %d missing.\n", len(chunks)-missing-corrupt, len(chunks), time.Since(start).Round(time.Millisecond), missing)
+	if corrupt > 0 {
+		errorf("%d chunks are corrupt.\n", corrupt)
+		return exitCorrupt
+	}
+
+	return exitOK
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Parse and check manifests without downloading anything, returns the exit code.
+// Binary manifests are checked against their embedded SHA-1 while parsing.
+func verifyManifests(sources []string) int {
+	failed := 0
+	for _, source := range sources {
+		var manifest *Manifest
+		var err error
+		if source == "-" {
+			manifest, err = readManifest(os.Stdin)
+		} else {
+			manifest, err = readManifestFile(source)
+		}
+		if err == nil {
+			err = checkManifestChunks(manifest)
+		}
+		if err != nil {
+			errorf("Manifest %s is invalid: %v\n", source, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("%s: %s %s, %d files, %d chunks, %s installed\n", source, manifest.AppNameString, manifest.BuildVersionString, len(manifest.FileManifestList), len(manifest.DataGroupList), formatBytes(int64(manifest.TotalInstallSize())))
+	}
+
+	if failed > 0 {
+		errorf("%d of %d manifests are invalid.\n", failed, len(sources))
+		return exitFatal
+	}
+
+	infof("%d manifests are valid.\n", len(sources))
+	return exitOK
+}
+
+// Check that every chunk used by a file is listed in the manifest, naming the first few that aren't
+func checkManifestChunks(manifest *Manifest) error {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, file := range manifest.FileManifestList {
+		for _, c := range file.FileChunkParts {
+			if seen[c.GUID] || manifest.HasChunk(c.GUID) {
+				continue
+			}
+			seen[c.GUID] = true
+			missing = append(missing, fmt.Sprintf("%s (used by %s)", c.GUID, file.FileName))
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	const shown = 10
+	if len(missing) > shown {
+		missing = append(missing[:shown], fmt.Sprintf("and %d more", len(missing)-shown))
+	}
+
+	return fmt.Errorf("%d chunks used by files are missing from the chunk lists: %s", len(seen), strings.Join(missing, ", "))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckManifestChunks(t *testing.T) {
+	const unknown = "00000000000000000000000000000BAD"
+
+	if err := checkManifestChunks(testBinaryManifest()); err != nil {
+		t.Fatalf("complete manifest rejected: %v", err)
+	}
+
+	// A chunk part using a GUID no chunk list has, twice so it's only named once
+	manifest := testBinaryManifest()
+	file := &manifest.FileManifestList[0]
+	file.FileChunkParts = append(file.FileChunkParts,
+		ManifestFileChunkPart{GUID: unknown, SizeInt: 10},
+		ManifestFileChunkPart{GUID: unknown, SizeInt: 10},
+	)
+	err := checkManifestChunks(manifest)
+	if err == nil {
+		t.Fatal("unknown chunk accepted")
+	}
+	if !strings.HasPrefix(err.Error(), "1 chunks") || !strings.Contains(err.Error(), unknown+" (used by "+file.FileName+")") {
+		t.Fatalf("got error %v", err)
+	}
+
+	// Chunks have to be in every list, not just some
+	manifest = testBinaryManifest()
+	delete(manifest.DataGroupList, "FEDCBA9876543210FEDCBA9876543210")
+	if err := checkManifestChunks(manifest); err == nil || !strings.Contains(err.Error(), "FEDCBA9876543210FEDCBA9876543210") {
+		t.Fatalf("got error %v for a chunk missing its data group", err)
+	}
+}
+
+func TestVerifyManifestsUnknownChunk(t *testing.T) {
+	logs := recordLogs(t)
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.manifest")
+	if err := ioutil.WriteFile(valid, encodeBinaryManifest(t, testBinaryManifest(), true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := verifyManifests([]string{valid}); code != exitOK {
+		t.Fatalf("valid manifest exited with %d", code)
+	}
+
+	manifest := testBinaryManifest()
+	manifest.FileManifestList[0].FileChunkParts[1].GUID = "00000000000000000000000000000BAD"
+	invalid := filepath.Join(dir, "invalid.manifest")
+	if err := ioutil.WriteFile(invalid, encodeBinaryManifest(t, manifest, true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := verifyManifests([]string{valid, invalid}); code != exitFatal {
+		t.Fatalf("manifest with an unknown chunk exited with %d", code)
+	}
+	if !logs.contains("chunks used by files are missing") {
+		t.Fatal("unknown chunk not reported")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Name of the verification state file in the install folder
+const verifyStateFile = ".splash-verified.json"
+
+// VerifyStateEntry defines a verified file, one json object is written per line
+type VerifyStateEntry struct {
+	Path    string `json:"path"`
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+// VerifyState records which installed files were already verified, so unchanged files aren't hashed again
+type VerifyState struct {
+	*stateFile
+	verified map[string]VerifyStateEntry
+}
+
+// IsVerified checks if a file was verified against hash and hasn't changed since
+func (vs *VerifyState) IsVerified(path string, hash string, fi os.FileInfo) bool {
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+
+	entry, ok := vs.verified[path]
+	return ok && entry.Hash == hash && entry.Size == fi.Size() && entry.ModTime == fi.ModTime().UnixNano()
+}
+
+// MarkVerified records a file as verified against hash
+func (vs *VerifyState) MarkVerified(path string, hash string, fi os.FileInfo) {
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+
+	entry := VerifyStateEntry{path, hash, fi.Size(), fi.ModTime().UnixNano()}
+	if vs.verified[path] == entry {
+		return
+	}
+
+	vs.verified[path] = entry
+	if err := vs.append(entry); err != nil {
+		warnf("Failed to write verification state: %v\n", err)
+	}
+}
+
+// Open the verification state of an install folder, creating it if needed
+func openVerifyState(dir string) (*VerifyState, error) {
+	state := &VerifyState{verified: make(map[string]VerifyStateEntry)}
+
+	var err error
+	state.stateFile, err = openStateFile(dir, verifyStateFile, func(line []byte) {
+		var entry VerifyStateEntry
+		if json.Unmarshal(line, &entry) == nil {
+			state.verified[entry.Path] = entry
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Set for the download runs started by -watch, so they don't watch themselves
+const watchChildEnv = "SPLASH_WATCH_CHILD"
+
+// Poll the catalog every -poll-interval and download whenever the build version changes.
+// Every download is a separate run of splash with the same flags, so no state carries over between builds.
+// Returns the exit code once interrupted.
+func watchCatalog() int {
+	executable, err := os.Executable()
+	if err != nil {
+		errorf("Failed to find splash executable: %v\n", err)
+		return exitFatal
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	infof("Watching the catalog every %s.\n", pollInterval)
+
+	lastVersion := ""
+	for {
+		element, err := pollCatalog()
+		if err != nil {
+			warnf("Failed to poll catalog: %v\n", err)
+		} else if element.BuildVersion == lastVersion {
+			debugf("Build %s is still current.\n", element.BuildVersion)
+		} else {
+			infof("Build %s found, downloading...\n", element.BuildVersion)
+
+			exitCode, interrupted := runWatchDownload(executable, signals)
+			if interrupted {
+				return exitCode
+			}
+
+			// Retry failed downloads on the next poll
+			if exitCode == exitOK {
+				lastVersion = element.BuildVersion
+				infof("Build %s is installed.\n", element.BuildVersion)
+			} else {
+				warnf("Download of build %s exited with %d, trying again next poll.\n", element.BuildVersion, exitCode)
+			}
+		}
+
+		select {
+		case <-signals:
+			infof("Stopped watching.")
+			return exitOK
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Fetch the catalog and pick the element to watch, by -catalog-element or the only one there is
+func pollCatalog() (CatalogElement, error) {
+	catalogBytes, err := fetchLiveCatalog()
+	if err != nil {
+		return CatalogElement{}, err
+	}
+
+	catalog, err := parseCatalog(catalogBytes)
+	if err != nil {
+		return CatalogElement{}, err
+	}
+
+	element := 0
+	if catalogElementName != "" {
+		element, err = catalog.FindElement(catalogElementName)
+		if err != nil {
+			return CatalogElement{}, err
+		}
+	} else if len(catalog.Elements) > 1 {
+		return CatalogElement{}, fmt.Errorf("catalog has %d elements, pick one with -catalog-element", len(catalog.Elements))
+	}
+
+	return catalog.Elements[element], nil
+}
+
+// Run splash once with the same flags, passing interrupts on so the download can stop gracefully.
+// Returns the exit code and whether the run was interrupted.
+func runWatchDownload(executable string, signals chan os.Signal) (int, bool) {
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), watchChildEnv+"=1")
+
+	if err := cmd.Start(); err != nil {
+		errorf("Failed to start download: %v\n", err)
+		return exitFatal, false
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	interrupted := false
+	for {
+		select {
+		case sig := <-signals:
+			interrupted = true
+			if runtime.GOOS == "windows" {
+				cmd.Process.Kill()
+			} else {
+				cmd.Process.Signal(sig)
+			}
+		case err := <-done:
+			if err == nil {
+				return exitOK, interrupted
+			}
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return exitErr.ExitCode(), interrupted
+			}
+			errorf("Download failed: %v\n", err)
+			return exitFatal, interrupted
+		}
+	}
+}